@@ -0,0 +1,648 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	pipelineStages = []string{
+		"$match",   // filter documents
+		"$group",   // group documents and compute accumulators
+		"$project", // include, exclude or rename fields
+		"$sort",    // sort documents
+		"$limit",   // limit the number of documents
+		"$skip",    // skip documents
+		"$count",   // count documents
+		"$lookup",  // join a related table
+	}
+
+	accumulators = []string{
+		"$sum",   // sum of values
+		"$avg",   // average of values
+		"$min",   // smallest value
+		"$max",   // largest value
+		"$count", // number of documents
+		"$push",  // array of values
+	}
+)
+
+// pipelineStage is a stage of an aggregation pipeline
+type pipelineStage struct {
+	name string
+	spec json.RawMessage
+}
+
+// aggLevel is a SELECT statement that one or more consecutive
+// stages of a pipeline are compiled into. When a stage cannot be
+// added to a level, the level becomes a subquery of a new level.
+type aggLevel struct {
+	from     string
+	fromArgs []interface{}
+
+	// fields holds the fields that stages can refer to
+	fields   FieldMap
+	anyField bool
+
+	// columns holds the selected fields in order.
+	// If empty, all columns are selected.
+	columns []string
+
+	// joins holds the JOIN clauses of the joined relations
+	joins  []string
+	joined []string
+
+	where      []string
+	whereArgs  []interface{}
+	groupBy    []string
+	grouped    bool
+	having     []string
+	havingArgs []interface{}
+	projected  bool
+	sort       []SortKey
+	limit      int
+	skip       int
+}
+
+// jsq returns a copy of q that resolves the fields of the level
+func (l *aggLevel) jsq(q *JSQ) *JSQ {
+	c := *q
+	c.b = nil
+	c.fields = l.fields
+	c.allowAnyField = l.anyField
+	return &c
+}
+
+// names returns the names of the fields the level outputs
+func (l *aggLevel) names() []string {
+	if len(l.columns) > 0 || len(l.joins) > 0 {
+		return l.selected()
+	}
+	names := make([]string, 0, len(l.fields))
+	for name := range l.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selected returns the fields the level selects. A level with
+// joins selects every known field instead of all columns, as the
+// joined tables may have columns of the same name.
+func (l *aggLevel) selected() []string {
+	if len(l.columns) > 0 || len(l.joins) == 0 {
+		return l.columns
+	}
+	names := make([]string, 0, len(l.fields))
+	for name := range l.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputColumn returns the name of the column a field is selected
+// as. Dots of the fields of relations are replaced by '__'.
+func outputColumn(field string) string {
+	return strings.Replace(field, ".", "__", -1)
+}
+
+// sql returns the SELECT statement of the level with '?' placeholders
+func (l *aggLevel) sql(q *JSQ) (string, []interface{}) {
+	c := l.jsq(q)
+
+	columns := "*"
+	if selected := l.selected(); len(selected) > 0 {
+		exprs := make([]string, len(selected))
+		for i, name := range selected {
+			exprs[i] = c.column(name)
+			if alias := q.dialect.QuoteIdent(outputColumn(name)); exprs[i] != alias {
+				exprs[i] += " AS " + alias
+			}
+		}
+		columns = strings.Join(exprs, ", ")
+	}
+
+	stmt := []string{"SELECT", columns, "FROM", l.from}
+	stmt = append(stmt, l.joins...)
+	args := append([]interface{}{}, l.fromArgs...)
+	if len(l.where) > 0 {
+		stmt = append(stmt, "WHERE", strings.Join(l.where, " AND "))
+		args = append(args, l.whereArgs...)
+	}
+	if len(l.groupBy) > 0 {
+		stmt = append(stmt, "GROUP BY", strings.Join(l.groupBy, ", "))
+	}
+	if len(l.having) > 0 {
+		stmt = append(stmt, "HAVING", strings.Join(l.having, " AND "))
+		args = append(args, l.havingArgs...)
+	}
+
+	var orderBy string
+	if len(l.sort) > 0 {
+		keys := make([]string, len(l.sort))
+		for i, key := range l.sort {
+			keys[i] = q.dialect.OrderBy(c.column(key.Field), key.Desc, key.Nulls)
+		}
+		orderBy = "ORDER BY " + strings.Join(keys, ", ")
+	}
+	limit := q.dialect.LimitOffset(l.limit, l.skip)
+	if orderBy == "" && limit != "" && offsetNeedsOrder(q.dialect) {
+		orderBy = "ORDER BY (SELECT NULL)"
+	}
+	if orderBy != "" {
+		stmt = append(stmt, orderBy)
+	}
+	if limit != "" {
+		stmt = append(stmt, limit)
+	}
+	return strings.Join(stmt, " "), args
+}
+
+// wrap returns a new level that selects from the level. Sort keys
+// of a level without a limit are moved to the new level, as the
+// order of the rows of a subquery is not preserved.
+func (l *aggLevel) wrap(q *JSQ, n int) *aggLevel {
+	var carried []SortKey
+	if l.limit == 0 && l.skip == 0 {
+		carried, l.sort = l.sort, nil
+	}
+
+	stmt, args := l.sql(q)
+	next := &aggLevel{
+		from:     fmt.Sprintf("(%s) %s", stmt, q.dialect.QuoteIdent(fmt.Sprintf("t%d", n))),
+		fromArgs: args,
+		fields:   FieldMap{},
+	}
+
+	if selected := l.selected(); len(selected) == 0 {
+		// all columns are selected, so fields keep their columns
+		next.anyField = l.anyField
+		for name, f := range l.fields {
+			f.Table, f.expr = "", ""
+			next.fields[name] = f
+		}
+	} else {
+		c := l.jsq(q)
+		for _, name := range selected {
+			f, _ := c.getField(name)
+			next.fields[name] = Field{Column: outputColumn(name), Type: f.Type, Values: f.Values, Ops: f.Ops}
+		}
+	}
+
+	c := next.jsq(q)
+	for _, key := range carried {
+		if c.isValidField(key.Field) {
+			next.sort = append(next.sort, key)
+		}
+	}
+	return next
+}
+
+// ParsePipeline parses an aggregation pipeline such as:
+//
+//	[
+//		{"$match": {"status": "paid"}},
+//		{"$group": {"_id": "$country", "total": {"$sum": "$amount"}}},
+//		{"$match": {"total": {"$gt": 1000}}},
+//		{"$sort": {"total": -1}},
+//		{"$limit": 10}
+//	]
+//
+// $match stages are parsed as with Parse. A $match that follows a
+// $group filters the groups with HAVING. Stages that cannot be added
+// to the same SELECT statement select from a subquery.
+func (q *JSQ) ParsePipeline(jsonPipeline string) error {
+	q.pipeline = nil
+	if err := q.checkInputLimit([]byte(jsonPipeline)); err != nil {
+		return err
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal([]byte(jsonPipeline), &raws); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	if len(raws) == 0 {
+		return fmt.Errorf("pipeline: expects at least one stage")
+	}
+
+	var stages []pipelineStage
+	for i, raw := range raws {
+		var stage map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &stage); err != nil || len(stage) != 1 {
+			return fmt.Errorf("pipeline: stage %d must be an object with a single key", i)
+		}
+		for name, spec := range stage {
+			if !q.isValidOperator(name, pipelineStages) {
+				return fmt.Errorf("pipeline: unknown stage: %s", name)
+			}
+			stages = append(stages, pipelineStage{name: name, spec: spec})
+		}
+	}
+
+	// compile the pipeline to validate it
+	if _, _, err := q.compilePipeline(stages, ""); err != nil {
+		return err
+	}
+	q.pipeline = stages
+	return nil
+}
+
+// Aggregate returns a SELECT statement that runs the parsed
+// aggregation pipeline over the rows of table
+func (q *JSQ) Aggregate(table string) (string, []interface{}, error) {
+	if _, err := q.quoteTable(table); err != nil {
+		return "", nil, err
+	}
+	if len(q.pipeline) == 0 {
+		return "", nil, fmt.Errorf("pipeline: no pipeline parsed")
+	}
+	stmt, args, err := q.compilePipeline(q.pipeline, table)
+	if err != nil {
+		return "", nil, err
+	}
+	return rebind(q.dialect, stmt), args, nil
+}
+
+// compilePipeline compiles the stages of a pipeline into a SELECT
+// statement over table with '?' placeholders. The table may be empty
+// to only validate the pipeline.
+func (q *JSQ) compilePipeline(stages []pipelineStage, table string) (string, []interface{}, error) {
+	from, _ := q.quoteTable(table)
+	level := &aggLevel{from: from, fields: q.fields, anyField: q.allowAnyField}
+	subqueries := 0
+	wrap := func() {
+		subqueries++
+		level = level.wrap(q, subqueries)
+	}
+
+	for _, stage := range stages {
+		var err error
+		switch stage.name {
+		case "$match":
+			if level.projected || level.limit > 0 || level.skip > 0 {
+				wrap()
+			}
+			err = q.matchStage(level, stage.spec)
+
+		case "$group":
+			if level.grouped || level.projected || level.limit > 0 || level.skip > 0 {
+				wrap()
+			}
+			err = q.groupStage(level, stage.spec)
+
+		case "$project":
+			if level.projected {
+				wrap()
+			}
+			err = q.projectStage(level, stage.spec)
+
+		case "$sort":
+			if level.limit > 0 || level.skip > 0 {
+				wrap()
+			}
+			err = q.sortStage(level, stage.spec)
+
+		case "$limit":
+			var n int
+			if n, err = parseCount("$limit", stage.spec); err == nil && n == 0 {
+				err = fmt.Errorf("$limit: expects a positive integer")
+			}
+			if level.limit > 0 {
+				wrap()
+			}
+			level.limit = n
+
+		case "$skip":
+			var n int
+			n, err = parseCount("$skip", stage.spec)
+			if level.limit > 0 || level.skip > 0 {
+				wrap()
+			}
+			level.skip = n
+
+		case "$count":
+			if level.grouped || level.projected || level.limit > 0 || level.skip > 0 {
+				wrap()
+			}
+			err = q.countStage(level, stage.spec)
+
+		case "$lookup":
+			if subqueries > 0 || level.grouped || level.projected || level.limit > 0 || level.skip > 0 {
+				return "", nil, fmt.Errorf("$lookup: must precede stages that group, project or limit rows")
+			}
+			err = q.lookupStage(level, stage.spec, table)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	stmt, args := level.sql(q)
+	return stmt, args, nil
+}
+
+// matchStage adds the filter of a $match stage to the WHERE
+// clause of a level, or to the HAVING clause of a grouped level
+func (q *JSQ) matchStage(level *aggLevel, spec json.RawMessage) error {
+	var filter map[string]interface{}
+	if err := json.Unmarshal(spec, &filter); err != nil {
+		return fmt.Errorf("$match: expects an object")
+	}
+	if len(filter) == 0 {
+		return nil
+	}
+
+	c := level.jsq(q)
+	if err := c.parse(spec); err != nil {
+		return err
+	}
+	if c.isEmptyBuilder() {
+		return nil
+	}
+	sql, args, err := c.b.ToSQL()
+	if err != nil {
+		return err
+	}
+
+	if level.grouped {
+		level.having = append(level.having, "("+sql+")")
+		level.havingArgs = append(level.havingArgs, args...)
+		return nil
+	}
+	level.where = append(level.where, "("+sql+")")
+	level.whereArgs = append(level.whereArgs, args...)
+	return nil
+}
+
+// groupStage groups the rows of a level. The _id of the stage is
+// null, a field reference such as "$country" or an object of field
+// references whose keys name the group columns. Each other key
+// names an accumulator.
+func (q *JSQ) groupStage(level *aggLevel, spec json.RawMessage) error {
+	keys, err := orderedKeys(spec)
+	if err != nil {
+		return fmt.Errorf("$group: expects an object")
+	}
+	var group map[string]json.RawMessage
+	if err := json.Unmarshal(spec, &group); err != nil {
+		return fmt.Errorf("$group: expects an object")
+	}
+	if _, ok := group["_id"]; !ok {
+		return fmt.Errorf("$group: expects an _id")
+	}
+
+	c := level.jsq(q)
+	fields := FieldMap{}
+	var columns, groupBy []string
+
+	// ref resolves a field reference to the field and its expression
+	ref := func(v interface{}, context string) (Field, string, error) {
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, "$") || !c.isValidField(s[1:]) {
+			return Field{}, "", fmt.Errorf("%s: expects a reference to a known field, e.g. \"$field\"", context)
+		}
+		f, _ := c.getField(s[1:])
+		return f, c.column(s[1:]), nil
+	}
+
+	addGroup := func(name string, v interface{}) error {
+		f, expr, err := ref(v, "$group: _id")
+		if err != nil {
+			return err
+		}
+		fields[name] = Field{Column: name, Type: f.Type, Values: f.Values, Ops: f.Ops, expr: expr}
+		columns = append(columns, name)
+		groupBy = append(groupBy, expr)
+		return nil
+	}
+
+	var id interface{}
+	json.Unmarshal(group["_id"], &id)
+	switch v := id.(type) {
+	case nil:
+	case string:
+		if err := addGroup("_id", v); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		names, _ := orderedKeys(group["_id"])
+		for _, name := range names {
+			if !isValidIdentifier(name) {
+				return fmt.Errorf("$group: _id: invalid name: %s", name)
+			}
+			if err := addGroup(name, v[name]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("$group: _id: expects null, a field reference or an object")
+	}
+
+	for _, name := range keys {
+		if name == "_id" {
+			continue
+		}
+		if !isValidIdentifier(name) || fields[name].Column != "" {
+			return fmt.Errorf("$group: invalid name: %s", name)
+		}
+
+		var acc map[string]interface{}
+		if err := json.Unmarshal(group[name], &acc); err != nil || len(acc) != 1 {
+			return fmt.Errorf("$group: field '%s': expects an object with a single accumulator", name)
+		}
+		for op, arg := range acc {
+			if !q.isValidOperator(op, accumulators) {
+				return fmt.Errorf("$group: field '%s': unknown accumulator: %s", name, op)
+			}
+			context := fmt.Sprintf("$group: field '%s': '%s'", name, op)
+
+			f := Field{Column: name}
+			switch op {
+			case "$count":
+				if m, ok := arg.(map[string]interface{}); !ok || len(m) > 0 {
+					return fmt.Errorf("%s expects {}", context)
+				}
+				f.Type, f.expr = TypeInt, "COUNT(*)"
+
+			case "$sum":
+				if n, ok := arg.(float64); ok {
+					f.Type, f.expr = TypeFloat, fmt.Sprintf("SUM(%s)", strconv.FormatFloat(n, 'f', -1, 64))
+					break
+				}
+				_, expr, err := ref(arg, context)
+				if err != nil {
+					return err
+				}
+				f.Type, f.expr = TypeFloat, fmt.Sprintf("SUM(%s)", expr)
+
+			case "$avg":
+				_, expr, err := ref(arg, context)
+				if err != nil {
+					return err
+				}
+				f.Type, f.expr = TypeFloat, fmt.Sprintf("AVG(%s)", expr)
+
+			case "$min", "$max":
+				source, expr, err := ref(arg, context)
+				if err != nil {
+					return err
+				}
+				f.Type, f.Values = source.Type, source.Values
+				f.expr = fmt.Sprintf("%s(%s)", strings.ToUpper(op[1:]), expr)
+
+			case "$push":
+				_, expr, err := ref(arg, context)
+				if err != nil {
+					return err
+				}
+				agg, ok := arrayAgg(q.dialect, expr)
+				if !ok {
+					return fmt.Errorf("%s is not supported by the %s dialect", context, q.dialect.Name())
+				}
+				f.Type, f.expr = TypeJSON, agg
+			}
+
+			fields[name] = f
+			columns = append(columns, name)
+		}
+	}
+
+	if len(columns) == 0 {
+		return fmt.Errorf("$group: expects a group key or an accumulator")
+	}
+
+	level.fields, level.anyField = fields, false
+	level.columns, level.groupBy = columns, groupBy
+	level.grouped = true
+	level.sort = nil
+	return nil
+}
+
+// projectStage selects fields of a level. A field is included with
+// 1, excluded with 0 or renamed with a reference such as "$name".
+// Inclusion and exclusion cannot be mixed, but _id may be excluded.
+func (q *JSQ) projectStage(level *aggLevel, spec json.RawMessage) error {
+	names, err := orderedKeys(spec)
+	if err != nil {
+		return fmt.Errorf("$project: expects an object")
+	}
+	var project map[string]interface{}
+	if err := json.Unmarshal(spec, &project); err != nil {
+		return fmt.Errorf("$project: expects an object")
+	}
+
+	c := level.jsq(q)
+	fields := FieldMap{}
+	var include, exclude []string
+	for _, name := range names {
+		switch v := project[name].(type) {
+		case string:
+			if !isValidIdentifier(name) {
+				return fmt.Errorf("$project: invalid name: %s", name)
+			}
+			if !strings.HasPrefix(v, "$") || !c.isValidField(v[1:]) {
+				return fmt.Errorf("$project: field '%s': expects a reference to a known field, e.g. \"$field\"", name)
+			}
+			f, _ := c.getField(v[1:])
+			f.expr = c.column(v[1:])
+			fields[name] = f
+			include = append(include, name)
+		case float64, bool:
+			if !c.isValidField(name) {
+				return fmt.Errorf("$project: unknown field: %s", name)
+			}
+			if v == 1.0 || v == true {
+				fields[name], _ = c.getField(name)
+				include = append(include, name)
+			} else if v == 0.0 || v == false {
+				exclude = append(exclude, name)
+			} else {
+				return fmt.Errorf("$project: field '%s': expects 1, 0 or a field reference", name)
+			}
+		default:
+			return fmt.Errorf("$project: field '%s': expects 1, 0 or a field reference", name)
+		}
+	}
+
+	if len(include) > 0 && (len(exclude) > 1 || (len(exclude) == 1 && exclude[0] != "_id")) {
+		return fmt.Errorf("$project: cannot mix inclusion and exclusion")
+	}
+
+	if len(include) == 0 {
+		if len(level.columns) == 0 && level.anyField {
+			return fmt.Errorf("$project: exclusion requires a field whitelist")
+		}
+		for _, name := range level.names() {
+			excluded := false
+			for _, e := range exclude {
+				excluded = excluded || e == name
+			}
+			if !excluded {
+				fields[name], _ = c.getField(name)
+				include = append(include, name)
+			}
+		}
+		if len(include) == 0 {
+			return fmt.Errorf("$project: excludes every field")
+		}
+	}
+
+	level.fields, level.anyField = fields, false
+	level.columns = include
+	level.projected = true
+
+	// sort keys on fields that are projected away are dropped
+	var sortKeys []SortKey
+	for _, key := range level.sort {
+		if _, ok := fields[key.Field]; ok {
+			sortKeys = append(sortKeys, key)
+		}
+	}
+	level.sort = sortKeys
+	return nil
+}
+
+// sortStage sets the sort keys of a level
+func (q *JSQ) sortStage(level *aggLevel, spec json.RawMessage) error {
+	names, err := orderedKeys(spec)
+	if err != nil || len(names) == 0 {
+		return fmt.Errorf("$sort: expects an object with at least one field")
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(spec, &values); err != nil {
+		return fmt.Errorf("$sort: expects an object with at least one field")
+	}
+
+	c := level.jsq(q)
+	var keys []SortKey
+	for _, name := range names {
+		if !c.isValidField(name) {
+			return fmt.Errorf("$sort: unknown field: %s", name)
+		}
+		key, err := parseSortKey(name, values[name])
+		if err != nil {
+			return fmt.Errorf("$%s", err)
+		}
+		keys = append(keys, key)
+	}
+	level.sort = keys
+	return nil
+}
+
+// countStage replaces the rows of a level with their count
+func (q *JSQ) countStage(level *aggLevel, spec json.RawMessage) error {
+	var name string
+	if err := json.Unmarshal(spec, &name); err != nil || !isValidIdentifier(name) {
+		return fmt.Errorf("$count: expects a valid field name")
+	}
+	level.fields = FieldMap{name: {Column: name, Type: TypeInt, expr: "COUNT(*)"}}
+	level.anyField = false
+	level.columns = []string{name}
+	level.projected = true
+	level.sort = nil
+	return nil
+}
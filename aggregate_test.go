@@ -0,0 +1,140 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregate(t *testing.T) {
+	Convey("Aggregate", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"status":  {Type: TypeString},
+			"country": {Type: TypeString},
+			"city":    {Type: TypeString},
+			"amount":  {Type: TypeDecimal},
+			"placed":  {Column: "placed_at", Type: TypeTimestamp},
+			"id":      {Type: TypeInt},
+		})
+		So(err, ShouldBeNil)
+
+		aggregate := func(pipeline string) (string, []interface{}, error) {
+			if err := jsq.ParsePipeline(pipeline); err != nil {
+				return "", nil, err
+			}
+			return jsq.Aggregate("orders")
+		}
+
+		Convey(".ParsePipeline", func() {
+			Convey("Should return error if a stage is unknown", func() {
+				err := jsq.ParsePipeline(`[{"$unwind": "$items"}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "pipeline: unknown stage: $unwind")
+			})
+
+			Convey("Should return error if a stage has more than one key", func() {
+				err := jsq.ParsePipeline(`[{"$match": {}, "$limit": 1}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "pipeline: stage 0 must be an object with a single key")
+			})
+
+			Convey("Should return error if a $match field is unknown", func() {
+				err := jsq.ParsePipeline(`[{"$match": {"password": "x"}}]`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return error if a field is referenced after it was grouped away", func() {
+				err := jsq.ParsePipeline(`[{"$group": {"_id": "$country"}}, {"$match": {"city": "Lagos"}}]`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return error if an accumulator references an unknown field", func() {
+				err := jsq.ParsePipeline(`[{"$group": {"_id": null, "total": {"$sum": "$price"}}}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `$group: field 'total': '$sum': expects a reference to a known field, e.g. "$field"`)
+			})
+
+			Convey("Should return error if an accumulator is unknown", func() {
+				err := jsq.ParsePipeline(`[{"$group": {"_id": null, "total": {"$first": "$amount"}}}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$group: field 'total': unknown accumulator: $first")
+			})
+		})
+
+		Convey("Should compile $match into WHERE", func() {
+			sql, args, err := aggregate(`[{"$match": {"status": "paid"}}, {"$sort": {"placed": -1}}, {"$limit": 5}]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "orders" WHERE ("status" = $1) ORDER BY "placed_at" DESC LIMIT 5`)
+			So(args, ShouldResemble, []interface{}{"paid"})
+		})
+
+		Convey("Should compile $group with HAVING", func() {
+			sql, args, err := aggregate(`[
+				{"$match": {"status": "paid"}},
+				{"$group": {"_id": "$country", "total": {"$sum": "$amount"}, "orders": {"$count": {}}, "ids": {"$push": "$id"}}},
+				{"$match": {"total": {"$gt": 1000}}},
+				{"$sort": {"total": -1}},
+				{"$limit": 10}
+			]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT "country" AS "_id", SUM("amount") AS "total", COUNT(*) AS "orders", json_agg("id") AS "ids" `+
+				`FROM "orders" WHERE ("status" = $1) GROUP BY "country" HAVING (SUM("amount") > $2) ORDER BY SUM("amount") DESC LIMIT 10`)
+			So(args, ShouldResemble, []interface{}{"paid", 1000.0})
+		})
+
+		Convey("Should group by several fields", func() {
+			sql, _, err := aggregate(`[{"$group": {"_id": {"country": "$country", "city": "$city"}, "avg": {"$avg": "$amount"}, "first": {"$min": "$placed"}}}]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT "country", "city", AVG("amount") AS "avg", MIN("placed_at") AS "first" FROM "orders" GROUP BY "country", "city"`)
+		})
+
+		Convey("Should aggregate all rows if _id is null", func() {
+			sql, _, err := aggregate(`[{"$group": {"_id": null, "n": {"$sum": 1}}}]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT SUM(1) AS "n" FROM "orders"`)
+		})
+
+		Convey("Should select from a subquery when stages cannot be combined", func() {
+			sql, args, err := aggregate(`[
+				{"$sort": {"amount": -1}},
+				{"$limit": 100},
+				{"$match": {"country": "NG"}},
+				{"$count": "n"}
+			]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT COUNT(*) AS "n" FROM (SELECT * FROM "orders" ORDER BY "amount" DESC LIMIT 100) "t1" WHERE ("country" = $1)`)
+			So(args, ShouldResemble, []interface{}{"NG"})
+		})
+
+		Convey("Should filter projected fields in a subquery", func() {
+			sql, _, err := aggregate(`[
+				{"$project": {"where": "$country", "amount": 1}},
+				{"$sort": {"amount": 1}},
+				{"$match": {"where": "NG"}}
+			]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM (SELECT "country" AS "where", "amount" FROM "orders") "t1" WHERE ("where" = $1) ORDER BY "amount"`)
+		})
+
+		Convey("Should exclude fields", func() {
+			sql, _, err := aggregate(`[{"$project": {"amount": 0, "placed": 0, "id": 0}}]`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT "city", "country", "status" FROM "orders"`)
+		})
+
+		Convey("Should return error if $push is not supported by the dialect", func() {
+			jsq.dialect = SQLServer
+			err := jsq.ParsePipeline(`[{"$group": {"_id": "$country", "ids": {"$push": "$id"}}}]`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$group: field 'ids': '$push' is not supported by the sqlserver dialect")
+		})
+
+		Convey("Should return error for $push if the dialect does not implement DialectFeatures", func() {
+			jsq.dialect = customDialect{Postgres}
+			err := jsq.ParsePipeline(`[{"$group": {"_id": "$country", "ids": {"$push": "$id"}}}]`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$group: field 'ids': '$push' is not supported by the custom dialect")
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/util"
+	"github.com/go-xorm/builder"
+)
+
+var (
+	// arrayOperators are only supported by array fields
+	arrayOperators = []string{"$all", "$size", "$elemMatch"}
+
+	// arrayFieldOperators are the operators supported by array fields
+	arrayFieldOperators = []string{"$eq", "$ne", "$in", "$nin", "$exists", "$not", "$all", "$size", "$elemMatch"}
+)
+
+// elementField returns the field of the elements of an array field
+func elementField(f Field) Field {
+	return Field{
+		Column:          "value",
+		Table:           "elem",
+		Type:            f.Items,
+		Values:          f.Values,
+		CaseInsensitive: f.CaseInsensitive,
+		Ops:             f.Ops,
+		jsonText:        f.Type == TypeJSONArray,
+	}
+}
+
+// isArrayField checks whether the values of a field are arrays
+func (q *JSQ) isArrayField(field string) bool {
+	f, _ := q.getField(field)
+	return isArrayType(f.Type)
+}
+
+// checkArrayOperator ensures that array operators are applied to array
+// fields and that array fields are only compared with array operators
+func (q *JSQ) checkArrayOperator(field, op string) error {
+	if !q.isArrayField(field) {
+		if util.InStringSlice(arrayOperators, op) {
+			return fmt.Errorf("field '%s': '%s' operator is only supported by array fields", field, op)
+		}
+		return nil
+	}
+	if !util.InStringSlice(arrayFieldOperators, op) {
+		return fmt.Errorf("field '%s': '%s' operator is not supported by array fields", field, op)
+	}
+	if op != "$exists" && op != "$not" && !supportsArrays(q.dialect) {
+		return fmt.Errorf("field '%s': array fields are not supported by the %s dialect", field, q.dialect.Name())
+	}
+	return nil
+}
+
+// jsonElement returns an element of a JSON array as it is encoded
+// in the array. Values are already coerced to the items type.
+func jsonElement(f Field, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if f.Items == TypeDecimal {
+			return json.Number(v)
+		}
+	case time.Time:
+		if f.Items == TypeDate {
+			return v.Format(dateLayout)
+		}
+	}
+	return v
+}
+
+// jsonArray returns the JSON encoding of values as
+// elements of the JSON array field f
+func jsonArray(f Field, values []interface{}) string {
+	elems := make([]interface{}, len(values))
+	for i, v := range values {
+		elems[i] = jsonElement(f, v)
+	}
+	bs, _ := json.Marshal(elems)
+	return string(bs)
+}
+
+// containsExpr returns an expression that checks whether the
+// array of a field contains a value, and the value to bind
+func (q *JSQ) containsExpr(field string, value interface{}) (string, interface{}) {
+	f, _ := q.getField(field)
+	if f.Type == TypeJSONArray {
+		return fmt.Sprintf("%s @> CAST(? AS JSONB)", q.column(field)), jsonArray(f, []interface{}{value})
+	}
+	return fmt.Sprintf("? = ANY(%s)", q.column(field)), value
+}
+
+// containsCond returns a condition that checks whether
+// the array of a field contains a value
+func (q *JSQ) containsCond(negate bool, field string, value interface{}) builder.Cond {
+	expr, arg := q.containsExpr(field, value)
+	return fieldExpr(negate, expr, arg)
+}
+
+// arrayInCond returns a condition that checks whether the array
+// of a field contains (or does not contain) any of the values.
+// A null value in the list matches null arrays.
+func (q *JSQ) arrayInCond(negate bool, field string, values []interface{}, not bool) builder.Cond {
+	var exprs []string
+	var args []interface{}
+	for _, v := range values {
+		if v == nil {
+			exprs = append(exprs, fmt.Sprintf("%s IS NULL", q.column(field)))
+			continue
+		}
+		expr, arg := q.containsExpr(field, v)
+		exprs = append(exprs, expr)
+		args = append(args, arg)
+	}
+	if len(exprs) == 0 {
+		return fieldExpr(negate != not, "1 = 0")
+	}
+	return fieldExpr(negate != not, "("+strings.Join(exprs, " OR ")+")", args...)
+}
+
+// allCond returns a condition that checks whether the
+// array of a field contains all the values
+func (q *JSQ) allCond(negate bool, field string, values []interface{}) builder.Cond {
+	if len(values) == 0 {
+		return fieldExpr(negate, "1 = 0")
+	}
+	f, _ := q.getField(field)
+	if f.Type == TypeJSONArray {
+		return fieldExpr(negate, fmt.Sprintf("%s @> CAST(? AS JSONB)", q.column(field)), jsonArray(f, values))
+	}
+	placeHolders := strings.TrimRight(strings.Repeat("?,", len(values)), ",")
+	return fieldExpr(negate, fmt.Sprintf("%s @> ARRAY[%s]", q.column(field), placeHolders), values...)
+}
+
+// sizeCond returns a condition that checks the
+// number of elements in the array of a field
+func (q *JSQ) sizeCond(negate bool, field string, size int64) builder.Cond {
+	f, _ := q.getField(field)
+	if f.Type == TypeJSONArray {
+		return fieldExpr(negate, fmt.Sprintf("jsonb_array_length(%s) = ?", q.column(field)), size)
+	}
+	return fieldExpr(negate, fmt.Sprintf("cardinality(%s) = ?", q.column(field)), size)
+}
+
+// elemMatchNode returns the node of the operators of an $elemMatch. The
+// operators of a map, as in an AST built by hand, are in sorted order.
+func (q *JSQ) elemMatchNode(field string, v interface{}) (Node, error) {
+	switch v := v.(type) {
+	case Node:
+		return v, nil
+	case map[string]interface{}:
+		var predicates int
+		nodes, err := q.fieldNodes(field, sortedObject(v).(*object), 0, &predicates)
+		if err != nil {
+			return nil, err
+		}
+		return joinNodes(nodes), nil
+	}
+	return nil, fmt.Errorf("field '%s': '$elemMatch' operator supports only map type", field)
+}
+
+// elemMatchCond returns a condition that checks whether an element
+// of the array of a field matches the compare operators of a node
+func (q *JSQ) elemMatchCond(negate bool, field string, node Node) (builder.Cond, error) {
+	f, _ := q.getField(field)
+	c := *q
+	c.b = nil
+	c.fields = FieldMap{field: elementField(f)}
+	c.allowAnyField = false
+	c.relations = nil
+	if err := c.build(node); err != nil {
+		return nil, err
+	}
+	sql, args, err := contextSQL(c.b, false)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := "unnest"
+	if f.Type == TypeJSONArray {
+		elements = "jsonb_array_elements_text"
+	}
+	exists := fmt.Sprintf("EXISTS (SELECT 1 FROM %s(%s) AS %s(%s) WHERE %s)", elements, q.column(field),
+		q.dialect.QuoteIdent("elem"), q.dialect.QuoteIdent("value"), sql)
+	return fieldExpr(negate, exists, args...), nil
+}
@@ -0,0 +1,141 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArray(t *testing.T) {
+	Convey("Array", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"tags":   {Type: TypeArray, Items: TypeString},
+			"scores": {Type: TypeJSONArray, Items: TypeInt},
+			"name":   {Type: TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		toSQL := func(query string) (string, []interface{}, error) {
+			if err := jsq.Parse(query); err != nil {
+				return "", nil, err
+			}
+			return jsq.ToSQL()
+		}
+
+		Convey(".SetFields", func() {
+			Convey("Should return error if items is set on a scalar field", func() {
+				err := jsq.SetFields(FieldMap{"name": {Type: TypeString, Items: TypeString}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': items type requires an array type")
+			})
+
+			Convey("Should return error if the items type is not a scalar type", func() {
+				err := jsq.SetFields(FieldMap{"tags": {Type: TypeArray, Items: TypeJSON}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'tags': unknown items type: json")
+			})
+		})
+
+		Convey("Should match arrays that contain a value", func() {
+			sql, args, err := toSQL(`{"tags": "red"}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `$1 = ANY("tags")`)
+			So(args, ShouldResemble, []interface{}{"red"})
+
+			sql, args, err = toSQL(`{"scores": {"$ne": 10}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `NOT ("scores" @> CAST($1 AS JSONB))`)
+			So(args, ShouldResemble, []interface{}{"[10]"})
+		})
+
+		Convey("Should match arrays that contain any value of $in", func() {
+			sql, args, err := toSQL(`{"tags": {"$in": ["red", "blue", null]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `($1 = ANY("tags") OR $2 = ANY("tags") OR "tags" IS NULL)`)
+			So(args, ShouldResemble, []interface{}{"red", "blue"})
+
+			sql, _, err = toSQL(`{"tags": {"$nin": ["red"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `NOT (($1 = ANY("tags")))`)
+		})
+
+		Convey("Should compile $all", func() {
+			sql, args, err := toSQL(`{"tags": {"$all": ["red", "blue"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"tags" @> ARRAY[$1,$2]`)
+			So(args, ShouldResemble, []interface{}{"red", "blue"})
+
+			sql, args, err = toSQL(`{"scores": {"$all": [1, "2"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"scores" @> CAST($1 AS JSONB)`)
+			So(args, ShouldResemble, []interface{}{"[1,2]"})
+		})
+
+		Convey("Should compile $size", func() {
+			sql, args, err := toSQL(`{"tags": {"$size": 2}, "scores": {"$not": {"$size": 0}}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `cardinality("tags") = $`)
+			So(sql, ShouldContainSubstring, `NOT (jsonb_array_length("scores") = $`)
+			So(args, ShouldContain, int64(2))
+
+			_, _, err = toSQL(`{"tags": {"$size": 1.5}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'tags': '$size' operator supports only non-negative integers")
+		})
+
+		Convey("Should compile $elemMatch", func() {
+			sql, args, err := toSQL(`{"scores": {"$elemMatch": {"$gte": 80, "$lt": 90}}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldStartWith, `EXISTS (SELECT 1 FROM jsonb_array_elements_text("scores") AS "elem"("value") WHERE `)
+			So(sql, ShouldContainSubstring, `CAST("elem"."value" AS NUMERIC) >= $`)
+			So(sql, ShouldContainSubstring, `CAST("elem"."value" AS NUMERIC) < $`)
+			So(args, ShouldContain, int64(80))
+
+			sql, args, err = toSQL(`{"tags": {"$elemMatch": {"$sw": "re"}}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM unnest("tags") AS "elem"("value") WHERE "elem"."value" LIKE $1 ESCAPE '\')`)
+			So(args, ShouldResemble, []interface{}{"re%"})
+		})
+
+		Convey("Should compile the operators of $elemMatch in query key order", func() {
+			sql, args, err := toSQL(`{"scores": {"$elemMatch": {"$lt": 90, "$gte": 80}}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM jsonb_array_elements_text("scores") AS "elem"("value") `+
+				`WHERE CAST("elem"."value" AS NUMERIC) < $1 AND CAST("elem"."value" AS NUMERIC) >= $2)`)
+			So(args, ShouldResemble, []interface{}{int64(90), int64(80)})
+
+			err = jsq.ParseNode(Compare{Field: "scores", Op: "$elemMatch", Value: map[string]interface{}{"$lt": 90.0, "$gte": 80.0}})
+			So(err, ShouldBeNil)
+			sql, _, err = jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEndWith, `WHERE CAST("elem"."value" AS NUMERIC) >= $1 AND CAST("elem"."value" AS NUMERIC) < $2)`)
+		})
+
+		Convey("Should return error if an element does not match the items type", func() {
+			_, _, err := toSQL(`{"scores": {"$elemMatch": {"$gt": "high"}}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'scores': '$gt' operator expects int value")
+		})
+
+		Convey("Should return error if an array operator is applied to a scalar field", func() {
+			_, _, err := toSQL(`{"name": {"$size": 1}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'name': '$size' operator is only supported by array fields")
+		})
+
+		Convey("Should return error if a scalar operator is applied to an array field", func() {
+			_, _, err := toSQL(`{"tags": {"$gt": "a"}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'tags': '$gt' operator is not supported by array fields")
+		})
+
+		Convey("Should return error if the dialect does not support arrays", func() {
+			err := jsq.Parse(`{"tags": "red"}`)
+			So(err, ShouldBeNil)
+			_, _, err = jsq.ToSQLFor(MySQL)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'tags': array fields are not supported by the mysql dialect")
+		})
+	})
+}
@@ -0,0 +1,508 @@
+package jsq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Node is a node of a query AST. It is one of And,
+// Or, Nor, Not, Compare and Expr.
+type Node interface {
+	node()
+}
+
+// And matches rows that match all of its nodes
+type And []Node
+
+// Or matches rows that match at least one of its nodes
+type Or []Node
+
+// Nor matches rows that match none of its nodes
+type Nor []Node
+
+// Not matches rows that do not match its node
+type Not struct {
+	Node Node
+}
+
+// Compare compares a field with a value using a compare
+// operator, e.g. {Field: "age", Op: "$gt", Value: 21.0}.
+// Values are decoded as by encoding/json, except that the
+// value of $elemMatch is the Node of the compare operators
+// that an element must match.
+type Compare struct {
+	Field string
+	Op    string
+	Value interface{}
+
+	// Options holds the $options of the operators of the
+	// field, or nil to use the default of the field
+	Options *string
+}
+
+// Expr matches rows for which an aggregation expression, as
+// accepted by the $expr operator, is true
+type Expr struct {
+	Value interface{}
+}
+
+func (And) node()     {}
+func (Or) node()      {}
+func (Nor) node()     {}
+func (Not) node()     {}
+func (Compare) node() {}
+func (Expr) node()    {}
+
+// ParseAST parses a JSON query into an AST. Only the structure of
+// the query is checked; fields, operators and values are validated
+// against the query configuration when the AST is passed to
+// ParseNode. Nodes follow the order of the keys in the query, so
+// the SQL of a query follows the order it was written in. An
+// empty query returns a nil node.
+func ParseAST(jsonQuery string) (Node, error) {
+	return new(JSQ).parseAST([]byte(jsonQuery))
+}
+
+// parseAST parses a JSON query into an AST
+func (q *JSQ) parseAST(data []byte) (Node, error) {
+	if err := q.checkInputLimit(data); err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed json")
+	}
+	if v == nil {
+		return nil, nil
+	}
+	stmt, ok := v.(*object)
+	if !ok {
+		return nil, fmt.Errorf("malformed json")
+	}
+	return q.objectAST(stmt)
+}
+
+// ast returns the AST of a json query object, or nil
+// if the query has no condition
+func (q *JSQ) ast(data json.RawMessage) (Node, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := v.(*object)
+	if !ok {
+		return nil, fmt.Errorf("expected an object")
+	}
+	return q.objectAST(stmt)
+}
+
+// objectAST returns the AST of a decoded query object,
+// or nil if the query has no condition
+func (q *JSQ) objectAST(stmt *object) (Node, error) {
+	var predicates int
+	nodes, err := q.statementNodes(stmt, 0, &predicates)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return joinNodes(nodes), nil
+}
+
+// joinNodes returns the conjunction of nodes
+func joinNodes(nodes []Node) Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return And(nodes)
+}
+
+// maxNesting is the maximum nesting of decoded
+// json values, as enforced by encoding/json
+const maxNesting = 10000
+
+// object is a decoded json object that keeps the order of its keys.
+// Only the first position of a duplicate key is kept, as the last
+// value wins.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// decodeJSON decodes a json document in a single pass. Objects are
+// decoded as *object and other values as by encoding/json.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	v, err := decodeValue(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after json value")
+	}
+	return v, nil
+}
+
+// decodeValue decodes the next value of a decoder nested in depth values
+func decodeValue(dec *json.Decoder, depth int) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return t, nil
+	}
+	if depth++; depth > maxNesting {
+		return nil, fmt.Errorf("exceeded max depth")
+	}
+
+	switch delim {
+	case '{':
+		obj := &object{values: map[string]interface{}{}}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := t.(string)
+			v, err := decodeValue(dec, depth)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = v
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+
+	case '[':
+		values := []interface{}{}
+		for dec.More() {
+			v, err := decodeValue(dec, depth)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected delimiter: %s", delim)
+}
+
+// plainValue returns a decoded value with its objects
+// converted to maps, as decoded by encoding/json
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *object:
+		m := make(map[string]interface{}, len(v.values))
+		for key, e := range v.values {
+			m[key] = plainValue(e)
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = plainValue(e)
+		}
+		return values
+	}
+	return v
+}
+
+// sortedObject returns a value with its maps converted to
+// objects whose keys are in sorted order
+func sortedObject(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		obj := &object{values: make(map[string]interface{}, len(v))}
+		for key, e := range v {
+			obj.keys = append(obj.keys, key)
+			obj.values[key] = sortedObject(e)
+		}
+		sort.Strings(obj.keys)
+		return obj
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = sortedObject(e)
+		}
+		return values
+	}
+	return v
+}
+
+// isObject checks whether a decoded value is an object
+func isObject(v interface{}) bool {
+	_, ok := v.(*object)
+	return ok
+}
+
+// objectKeys returns the keys of an object in the order they
+// are written in, or sorted if the query is canonical
+func (q *JSQ) objectKeys(obj *object) []string {
+	if !q.canonical {
+		return obj.keys
+	}
+	keys := append([]string(nil), obj.keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+// statementNodes returns the nodes of the keys of a statement nested
+// in depth logical operators and counts their predicates. The depth
+// and predicate limits are enforced as the nodes are created, so a
+// query is rejected at the first level or predicate over a limit.
+// Implicit $and nodes are only counted by checkLimits.
+func (q *JSQ) statementNodes(stmt *object, depth int, predicates *int) ([]Node, error) {
+	var nodes []Node
+	for _, field := range q.objectKeys(stmt) {
+		fieldValue := stmt.values[field]
+
+		// compare fields with an aggregation expression
+		if field == "$expr" {
+			if err := q.countPredicate(predicates); err != nil {
+				return nil, err
+			}
+			if err := q.checkDepthLimit(depth + exprDepth(fieldValue)); err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, Expr{Value: plainValue(fieldValue)})
+			continue
+		}
+
+		// field is not an operator
+		if !strings.HasPrefix(field, "$") {
+
+			// non-operator field can only have string, number, boolean, null or map value type
+			if !q.isScalar(fieldValue) && !isObject(fieldValue) {
+				return nil, fmt.Errorf("field '%s': invalid value type. expects string, number, boolean, null or map", field)
+			}
+
+			// when field value is a scalar, add equality condition
+			if q.isScalar(fieldValue) {
+				if err := q.countPredicate(predicates); err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, Compare{Field: field, Op: "$eq", Value: fieldValue})
+				continue
+			}
+
+			fieldNodes, err := q.fieldNodes(field, fieldValue.(*object), depth, predicates)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, fieldNodes...)
+			continue
+		}
+
+		// check if field is a known top level operator
+		if !q.isValidOperator(field, logicalOperators) {
+			return nil, fmt.Errorf("unknown top level operator: %s", field)
+		}
+
+		// field is an operator. Field must be an array of expressions
+		if !q.isArray(fieldValue) {
+			return nil, fmt.Errorf("field '%s': operator supports only array type", field)
+		}
+		if err := q.checkDepthLimit(depth + 1); err != nil {
+			return nil, err
+		}
+
+		var entries []Node
+		for _, entry := range fieldValue.([]interface{}) {
+
+			// statements must be maps
+			if !isObject(entry) {
+				return nil, fmt.Errorf("field '%s': '$and/$or' entries must be full objects", field)
+			}
+
+			entryNodes, err := q.statementNodes(entry.(*object), depth+1, predicates)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, joinNodes(entryNodes))
+		}
+
+		switch field {
+		case "$and":
+			nodes = append(nodes, And(entries))
+		case "$or":
+			nodes = append(nodes, Or(entries))
+		case "$nor":
+			nodes = append(nodes, Nor(entries))
+		}
+	}
+	return nodes, nil
+}
+
+// fieldNodes returns the nodes of the operators of a field nested
+// in depth logical operators and counts their predicates
+func (q *JSQ) fieldNodes(field string, ops *object, depth int, predicates *int) ([]Node, error) {
+
+	// ensure all map keys are valid operators
+	if err := q.validateCompareOperators(ops.values); err != nil {
+		return nil, fmt.Errorf("field '%s': %s", field, err)
+	}
+
+	var options *string
+	if opt, ok := ops.values["$options"]; ok {
+		s, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("field '%s': '$options' operator supports only string type", field)
+		}
+		options = &s
+	}
+
+	var nodes []Node
+	for _, op := range q.objectKeys(ops) {
+		opVal := ops.values[op]
+		switch op {
+		case "$options":
+			continue
+
+		case "$not":
+			if !isObject(opVal) {
+				return nil, fmt.Errorf("field '%s': '$not' operator supports only map type", field)
+			}
+
+			// ensure only compare operators are included
+			for op := range opVal.(*object).values {
+				if !q.isValidOperator(op, compareOperators) {
+					return nil, fmt.Errorf("bad value. unknown operator: %s", field)
+				}
+			}
+			if err := q.checkDepthLimit(depth + 1); err != nil {
+				return nil, err
+			}
+
+			negated, err := q.fieldNodes(field, opVal.(*object), depth+1, predicates)
+			if err != nil {
+				return nil, err
+			}
+			if len(negated) == 0 {
+				return nil, fmt.Errorf("field '%s': '$not' operator expects at least one operator", field)
+			}
+			nodes = append(nodes, Not{Node: joinNodes(negated)})
+
+		case "$elemMatch":
+			if err := q.countPredicate(predicates); err != nil {
+				return nil, err
+			}
+			value := plainValue(opVal)
+			if elem, ok := opVal.(*object); ok {
+				elemNodes, err := q.fieldNodes(field, elem, depth, predicates)
+				if err != nil {
+					return nil, err
+				}
+				value = joinNodes(elemNodes)
+			}
+			nodes = append(nodes, Compare{Field: field, Op: op, Value: value, Options: options})
+
+		default:
+			if err := q.countPredicate(predicates); err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, Compare{Field: field, Op: op, Value: plainValue(opVal), Options: options})
+		}
+	}
+
+	// options only apply to the compare operators next to them
+	if options != nil && !hasOptionsTarget(nodes) {
+		return nil, fmt.Errorf("field '%s': '$options' operator requires a string operator such as '$regex'", field)
+	}
+	return nodes, nil
+}
+
+// hasOptionsTarget checks whether field nodes include
+// a compare operator that $options applies to
+func hasOptionsTarget(nodes []Node) bool {
+	for _, n := range nodes {
+		if _, ok := n.(Compare); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk traverses an AST in depth-first order. It calls fn for
+// each node and visits its children if fn returns true.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	switch n := node.(type) {
+	case And:
+		walkAll(n, fn)
+	case Or:
+		walkAll(n, fn)
+	case Nor:
+		walkAll(n, fn)
+	case Not:
+		Walk(n.Node, fn)
+	}
+}
+
+func walkAll(nodes []Node, fn func(Node) bool) {
+	for _, n := range nodes {
+		Walk(n, fn)
+	}
+}
+
+// Rewrite returns a copy of an AST in which each node is replaced
+// by the result of fn. Children are rewritten before their parents.
+// A nil result removes the node from its parent, and a Not whose
+// node is removed is removed too. An Or whose nodes are all removed
+// matches no row. Rewrite stops at the first error.
+func Rewrite(node Node, fn func(Node) (Node, error)) (Node, error) {
+	var err error
+	switch n := node.(type) {
+	case nil:
+		return nil, nil
+	case And:
+		var nodes []Node
+		nodes, err = rewriteAll(n, fn)
+		node = And(nodes)
+	case Or:
+		var nodes []Node
+		nodes, err = rewriteAll(n, fn)
+		node = Or(nodes)
+	case Nor:
+		var nodes []Node
+		nodes, err = rewriteAll(n, fn)
+		node = Nor(nodes)
+	case Not:
+		var child Node
+		if child, err = Rewrite(n.Node, fn); err == nil && child == nil {
+			return nil, nil
+		}
+		node = Not{Node: child}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fn(node)
+}
+
+func rewriteAll(nodes []Node, fn func(Node) (Node, error)) ([]Node, error) {
+	var rewritten []Node
+	for _, n := range nodes {
+		r, err := Rewrite(n, fn)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			rewritten = append(rewritten, r)
+		}
+	}
+	return rewritten, nil
+}
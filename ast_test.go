@@ -0,0 +1,290 @@
+package jsq
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAST(t *testing.T) {
+	Convey("AST", t, func() {
+		jsq := NewJSQWithDialect([]string{"name", "age", "user_id"}, Postgres)
+
+		Convey("ParseAST", func() {
+			Convey("Should parse fields and logical operators", func() {
+				node, err := ParseAST(`{"$or": [{"name": "ben"}, {"age": {"$not": {"$gt": 21}}}], "$nor": [{}]}`)
+				So(err, ShouldBeNil)
+				and, ok := node.(And)
+				So(ok, ShouldBeTrue)
+				So(and, ShouldHaveLength, 2)
+				So(and, ShouldContain, Or{
+					Compare{Field: "name", Op: "$eq", Value: "ben"},
+					Not{Node: Compare{Field: "age", Op: "$gt", Value: 21.0}},
+				})
+				So(and, ShouldContain, Nor{And(nil)})
+			})
+
+			Convey("Should keep the options of a field", func() {
+				node, err := ParseAST(`{"name": {"$sw": "b", "$options": "i"}}`)
+				So(err, ShouldBeNil)
+				So(node.(Compare).Options, ShouldNotBeNil)
+				So(*node.(Compare).Options, ShouldEqual, "i")
+			})
+
+			Convey("Should return nil if the query is empty", func() {
+				node, err := ParseAST(`{}`)
+				So(err, ShouldBeNil)
+				So(node, ShouldBeNil)
+			})
+
+			Convey("Should parse deeply nested queries", func() {
+				node, err := ParseAST(deepQuery(2000))
+				So(err, ShouldBeNil)
+				depth := 0
+				for and, ok := node.(And); ok; and, ok = node.(And) {
+					depth++
+					node = and[0]
+				}
+				So(depth, ShouldEqual, 2000)
+				So(node, ShouldResemble, Compare{Field: "age", Op: "$eq", Value: 1.0})
+			})
+
+			Convey("Should return error if the json is malformed", func() {
+				_, err := ParseAST(`{"name": "ben"} {}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "malformed json")
+
+				_, err = ParseAST(`["name"]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "malformed json")
+			})
+
+			Convey("Should return error if the structure is invalid", func() {
+				_, err := ParseAST(`{"$xor": []}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown top level operator: $xor")
+
+				_, err = ParseAST(`{"name": {"$bad": 1}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': bad value. unknown operator: $bad")
+
+				_, err = ParseAST(`{"name": {"$not": {}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': '$not' operator expects at least one operator")
+			})
+		})
+
+		Convey("Walk", func() {
+			node, err := ParseAST(`{"$or": [{"name": "ben"}, {"$and": [{"age": 21}]}]}`)
+			So(err, ShouldBeNil)
+
+			var fields []string
+			Walk(node, func(n Node) bool {
+				if c, ok := n.(Compare); ok {
+					fields = append(fields, c.Field)
+				}
+				return true
+			})
+			So(fields, ShouldResemble, []string{"name", "age"})
+
+			var visited int
+			Walk(node, func(n Node) bool {
+				visited++
+				_, isOr := n.(Or)
+				return !isOr
+			})
+			So(visited, ShouldEqual, 1)
+		})
+
+		Convey("Rewrite", func() {
+			Convey("Should rename fields", func() {
+				node, err := ParseAST(`{"userId": 1, "$or": [{"name": "ben"}]}`)
+				So(err, ShouldBeNil)
+				node, err = Rewrite(node, func(n Node) (Node, error) {
+					if c, ok := n.(Compare); ok && c.Field == "userId" {
+						c.Field = "user_id"
+						return c, nil
+					}
+					return n, nil
+				})
+				So(err, ShouldBeNil)
+				err = jsq.ParseNode(node)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldContainSubstring, `"user_id" = $`)
+			})
+
+			Convey("Should remove nodes", func() {
+				node, err := ParseAST(`{"$and": [{"age": {"$not": {"$gt": 21}}}, {"name": "ben"}]}`)
+				So(err, ShouldBeNil)
+				node, err = Rewrite(node, func(n Node) (Node, error) {
+					if c, ok := n.(Compare); ok && c.Field == "age" {
+						return nil, nil
+					}
+					return n, nil
+				})
+				So(err, ShouldBeNil)
+				So(node, ShouldResemble, And{Compare{Field: "name", Op: "$eq", Value: "ben"}})
+			})
+
+			Convey("Should match no row if every node of an $or is removed", func() {
+				node, err := ParseAST(`{"age": 21, "$or": [{"name": "ben"}, {"name": "ann"}]}`)
+				So(err, ShouldBeNil)
+				node, err = Rewrite(node, func(n Node) (Node, error) {
+					if c, ok := n.(Compare); ok && c.Field == "name" {
+						return nil, nil
+					}
+					return n, nil
+				})
+				So(err, ShouldBeNil)
+				err = jsq.ParseNode(node)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"age" = $1 AND 1 = 0`)
+			})
+
+			Convey("Should stop at the first error", func() {
+				node, err := ParseAST(`{"name": "ben", "age": 21}`)
+				So(err, ShouldBeNil)
+				_, err = Rewrite(node, func(n Node) (Node, error) {
+					if c, ok := n.(Compare); ok {
+						return nil, fmt.Errorf("field '%s' is not allowed", c.Field)
+					}
+					return n, nil
+				})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".ParseNode", func() {
+			Convey("Should generate SQL from an AST", func() {
+				err := jsq.ParseNode(Or{
+					Compare{Field: "name", Op: "$sw", Value: "b"},
+					Not{Node: And{
+						Compare{Field: "age", Op: "$gte", Value: 18},
+						Compare{Field: "age", Op: "$lt", Value: 21},
+					}},
+				})
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `("name" LIKE $1 ESCAPE '\' OR NOT ("age" >= $2 AND "age" < $3))`)
+				So(args, ShouldResemble, []interface{}{"b%", 18, 21})
+			})
+
+			Convey("Should negate entries of $nor as a whole", func() {
+				err := jsq.Parse(`{"$nor": [{"name": "ben", "age": 21}]}`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `NOT ("name" = $1 AND "age" = $2)`)
+			})
+
+			Convey("Should negate an empty $or", func() {
+				err := jsq.Parse(`{"$or": []}`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `1 = 0`)
+
+				err = jsq.Parse(`{"$nor": [{"$or": []}]}`)
+				So(err, ShouldBeNil)
+				sql, _, err = jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `1 = 1`)
+
+				err = jsq.ParseNode(Not{Node: Or{}})
+				So(err, ShouldBeNil)
+				sql, _, err = jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `1 = 1`)
+
+				err = jsq.Parse(`{"$nor": [{"$or": [{"name": "ben"}, {"$or": []}]}]}`)
+				So(err, ShouldBeNil)
+				sql, _, err = jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `NOT (("name" = $1 OR 1 = 0))`)
+			})
+
+			Convey("Should return error if a field is unknown", func() {
+				err := jsq.ParseNode(Compare{Field: "password", Op: "$eq", Value: "x"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown query field: password")
+			})
+
+			Convey("Should return error if an operator is unknown", func() {
+				err := jsq.ParseNode(Compare{Field: "name", Op: "$not", Value: "x"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': bad value. unknown operator: $not")
+			})
+
+			Convey("Should return error if a node is nil", func() {
+				err := jsq.ParseNode(Or{nil})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unexpected nil node")
+			})
+		})
+
+		Convey("Key order", func() {
+			Convey("Should follow the order of the keys in the query", func() {
+				node, err := ParseAST(`{"name": "ben", "age": {"$lt": 30, "$gt": 21}, "user_id": 1}`)
+				So(err, ShouldBeNil)
+				So(node, ShouldResemble, And{
+					Compare{Field: "name", Op: "$eq", Value: "ben"},
+					Compare{Field: "age", Op: "$lt", Value: 30.0},
+					Compare{Field: "age", Op: "$gt", Value: 21.0},
+					Compare{Field: "user_id", Op: "$eq", Value: 1.0},
+				})
+
+				for i := 0; i < 10; i++ {
+					So(jsq.Parse(`{"user_id": 1, "name": "ben", "age": 21}`), ShouldBeNil)
+					sql, args, err := jsq.ToSQL()
+					So(err, ShouldBeNil)
+					So(sql, ShouldEqual, `"user_id" = $1 AND "name" = $2 AND "age" = $3`)
+					So(args, ShouldResemble, []interface{}{1.0, "ben", 21.0})
+				}
+			})
+
+			Convey("Should keep the first position of duplicate keys", func() {
+				node, err := ParseAST(`{"name": "ben", "age": 21, "name": "joe"}`)
+				So(err, ShouldBeNil)
+				So(node, ShouldResemble, And{
+					Compare{Field: "name", Op: "$eq", Value: "joe"},
+					Compare{Field: "age", Op: "$eq", Value: 21.0},
+				})
+			})
+
+			Convey("Should sort the keys of canonical queries", func() {
+				jsq.SetCanonical(true)
+				So(jsq.Parse(`{"user_id": 1, "$or": [{"name": "ben", "age": 21}], "age": {"$lt": 30, "$gt": 21}}`), ShouldBeNil)
+				sql, args, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `("age" = $1 AND "name" = $2) AND "age" > $3 AND "age" < $4 AND "user_id" = $5`)
+				So(args, ShouldResemble, []interface{}{21.0, "ben", 21.0, 30.0, 1.0})
+
+				So(jsq.Parse(`{"age": {"$gt": 21, "$lt": 30}, "user_id": 1, "$or": [{"age": 21, "name": "ben"}]}`), ShouldBeNil)
+				sql2, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql2, ShouldEqual, sql)
+			})
+		})
+	})
+}
+
+// deepQuery returns a query of depth nested $and operators
+func deepQuery(depth int) string {
+	return strings.Repeat(`{"$and": [`, depth) + `{"age": 1}` + strings.Repeat(`]}`, depth)
+}
+
+func BenchmarkParseDeepQuery(b *testing.B) {
+	query := deepQuery(2000)
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAST(query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package jsq
+
+// Compiler compiles queries against a snapshot of the configuration
+// of a JSQ. Unlike a JSQ, which holds the last parsed query, a
+// compiler is immutable and safe for concurrent use.
+type Compiler struct {
+	q JSQ
+}
+
+// CompiledQuery is an immutable query compiled by a Compiler
+type CompiledQuery struct {
+	sql     string
+	args    []interface{}
+	node    Node
+	fields  []string
+	dialect Dialect
+}
+
+// Compiler returns a compiler that uses the current configuration
+// of the query. Later changes to the query do not affect it.
+func (q *JSQ) Compiler() *Compiler {
+	c := &Compiler{q: *q}
+
+	// the configuration may share maps and slices with the
+	// caller, e.g. the field map passed to SetFields, so they
+	// are copied and the parsed state is dropped
+	c.q.fields = copyFields(q.fields)
+	c.q.relations = copyRelations(q.relations)
+	c.q.cursorKey = append([]byte(nil), q.cursorKey...)
+	c.q.node, c.q.b = nil, nil
+	c.q.options = FindOptions{}
+	c.q.assignments = nil
+	c.q.documents = documents{}
+	c.q.upsert = nil
+	c.q.pipeline = nil
+	return c
+}
+
+// Compile parses a JSON query and generates its SQL
+func (c *Compiler) Compile(jsonQuery string) (*CompiledQuery, error) {
+	node, err := c.q.parseAST([]byte(jsonQuery))
+	if err != nil {
+		return nil, err
+	}
+	return c.CompileNode(node)
+}
+
+// CompileNode validates an AST, such as one returned
+// by ParseAST, and generates its SQL
+func (c *Compiler) CompileNode(node Node) (*CompiledQuery, error) {
+	q := c.q
+	if err := q.build(node); err != nil {
+		return nil, err
+	}
+	sql, args, err := q.getSQL()
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledQuery{
+		sql:     rebind(q.dialect, sql),
+		args:    args,
+		node:    node,
+		fields:  nodeFields(node),
+		dialect: q.dialect,
+	}, nil
+}
+
+// SQL returns the condition of the query. It is
+// empty if the query has no condition.
+func (c *CompiledQuery) SQL() string {
+	return c.sql
+}
+
+// Args returns a copy of the arguments of the condition
+func (c *CompiledQuery) Args() []interface{} {
+	return append([]interface{}(nil), c.args...)
+}
+
+// Node returns the AST of the query. It must not be modified.
+func (c *CompiledQuery) Node() Node {
+	return c.node
+}
+
+// Fields returns the fields the query refers to
+// in the order they first appear in the query
+func (c *CompiledQuery) Fields() []string {
+	return append([]string(nil), c.fields...)
+}
+
+// Dialect returns the dialect the SQL is generated for
+func (c *CompiledQuery) Dialect() Dialect {
+	return c.dialect
+}
+
+// nodeFields returns the distinct fields an AST refers to,
+// including the fields referenced by expressions
+func nodeFields(node Node) []string {
+	var fields []string
+	seen := map[string]bool{}
+	add := func(field string) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	Walk(node, func(n Node) bool {
+		switch n := n.(type) {
+		case Compare:
+			add(n.Field)
+		case Expr:
+			exprFields(n.Value, add)
+		}
+		return true
+	})
+	return fields
+}
+
+// exprFields calls add with each field referenced by an expression
+func exprFields(v interface{}, add func(string)) {
+	switch v := v.(type) {
+	case string:
+		if len(v) > 1 && v[0] == '$' {
+			add(v[1:])
+		}
+	case []interface{}:
+		for _, e := range v {
+			exprFields(e, add)
+		}
+	case map[string]interface{}:
+		// operator objects have a single key
+		for _, arg := range v {
+			exprFields(arg, add)
+		}
+	}
+}
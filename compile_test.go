@@ -0,0 +1,99 @@
+package jsq
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompile(t *testing.T) {
+	Convey("Compile", t, func() {
+		jsq := NewJSQWithDialect([]string{"name", "age", "score"}, Postgres)
+		compiler := jsq.Compiler()
+
+		Convey("Should compile a query", func() {
+			q, err := compiler.Compile(`{"name": "ben", "age": {"$gt": 21}, "$expr": {"$gt": ["$score", "$age"]}}`)
+			So(err, ShouldBeNil)
+			So(q.SQL(), ShouldEqual, `"name" = $1 AND "age" > $2 AND "score" > "age"`)
+			So(q.Args(), ShouldResemble, []interface{}{"ben", 21.0})
+			So(q.Fields(), ShouldResemble, []string{"name", "age", "score"})
+			So(q.Dialect(), ShouldEqual, Postgres)
+			So(q.Node(), ShouldHaveLength, 3)
+		})
+
+		Convey("Should compile an empty query", func() {
+			q, err := compiler.Compile(`{}`)
+			So(err, ShouldBeNil)
+			So(q.SQL(), ShouldEqual, "")
+			So(q.Args(), ShouldBeEmpty)
+			So(q.Node(), ShouldBeNil)
+		})
+
+		Convey("Should not be affected by changes to the query", func() {
+			jsq.AllowAnyField(true)
+			So(jsq.Parse(`{"name": "joe"}`), ShouldBeNil)
+
+			_, err := compiler.Compile(`{"email": "ben@example.com"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: email")
+
+			q, err := jsq.Compiler().CompileNode(Compare{Field: "email", Op: "$eq", Value: "x"})
+			So(err, ShouldBeNil)
+			So(q.SQL(), ShouldEqual, `"email" = $1`)
+			sql, _, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"name" = $1`)
+		})
+
+		Convey("Should not be affected by changes to the field map", func() {
+			fields := FieldMap{"name": {Ops: []string{"$eq"}}}
+			So(jsq.SetFields(fields), ShouldBeNil)
+			compiler := jsq.Compiler()
+
+			fields["email"] = Field{}
+			fields["name"].Ops[0] = "$ne"
+			_, err := compiler.Compile(`{"email": "ben@example.com"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: email")
+
+			q, err := compiler.Compile(`{"name": "ben"}`)
+			So(err, ShouldBeNil)
+			So(q.SQL(), ShouldEqual, `"name" = $1`)
+		})
+
+		Convey("Should not share arguments", func() {
+			q, err := compiler.Compile(`{"name": "ben"}`)
+			So(err, ShouldBeNil)
+			q.Args()[0] = "joe"
+			So(q.Args(), ShouldResemble, []interface{}{"ben"})
+		})
+
+		Convey("Should return error if the query is invalid", func() {
+			_, err := compiler.Compile(`{"name": {"$bad": 1}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'name': bad value. unknown operator: $bad")
+		})
+
+		Convey("Should be safe for concurrent use", func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 20)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					q, err := compiler.Compile(fmt.Sprintf(`{"age": %d, "name": {"$in": ["a", "b"]}}`, i))
+					if err == nil && (q.SQL() != `"age" = $1 AND "name" IN ($2,$3)` || q.Args()[0] != float64(i)) {
+						err = fmt.Errorf("unexpected query: %s %v", q.SQL(), q.Args())
+					}
+					errs[i] = err
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+}
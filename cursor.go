@@ -0,0 +1,167 @@
+package jsq
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// cursorPayload is the signed content of a cursor
+type cursorPayload struct {
+
+	// Sort identifies the sort keys the cursor was created for
+	Sort string `json:"s"`
+
+	// Values holds the values of the sort keys of the last row
+	Values []interface{} `json:"v"`
+}
+
+// SetCursorKey sets the secret used to sign and verify cursors
+func (q *JSQ) SetCursorKey(key []byte) {
+	q.cursorKey = key
+}
+
+// EncodeCursor returns an opaque, signed cursor that points after a
+// row. values holds the value of each sort key of the row, keyed by
+// public field name. Pass the cursor as the "cursor" find option to
+// get the next page. The sort keys should end with a unique field.
+func (q *JSQ) EncodeCursor(values map[string]interface{}) (string, error) {
+	if len(q.cursorKey) == 0 {
+		return "", fmt.Errorf("cursor key is not set")
+	}
+	if len(q.options.Sort) == 0 {
+		return "", fmt.Errorf("cursor requires sort keys")
+	}
+
+	payload := cursorPayload{Sort: sortSignature(q.options.Sort)}
+	for _, key := range q.options.Sort {
+		if key.Nulls != "" {
+			return "", fmt.Errorf("cursor: sort key '%s' cannot set nulls order", key.Field)
+		}
+		v, ok := values[key.Field]
+		if !ok || v == nil {
+			return "", fmt.Errorf("cursor: missing value for sort key: %s", key.Field)
+		}
+		payload.Values = append(payload.Values, v)
+	}
+
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("cursor: %s", err)
+	}
+	data := base64.RawURLEncoding.EncodeToString(bs)
+	return data + "." + q.signCursor(data), nil
+}
+
+// decodeCursor verifies a cursor and returns the sort
+// key values it holds, coerced to the field types
+func (q *JSQ) decodeCursor(cursor string, sort []SortKey) ([]interface{}, error) {
+	if len(q.cursorKey) == 0 {
+		return nil, fmt.Errorf("cursor key is not set")
+	}
+
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(q.signCursor(parts[0]))) {
+		return nil, fmt.Errorf("cursor: invalid cursor")
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("cursor: invalid cursor")
+	}
+	// decode numbers as json.Number, so that large integers keep their precision
+	var payload cursorPayload
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("cursor: invalid cursor")
+	}
+
+	if payload.Sort != sortSignature(sort) || len(payload.Values) != len(sort) {
+		return nil, fmt.Errorf("cursor: sort does not match the cursor")
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, key := range sort {
+		if key.Nulls != "" {
+			return nil, fmt.Errorf("cursor: sort key '%s' cannot set nulls order", key.Field)
+		}
+		v, err := q.value(key.Field, "cursor", payload.Values[i])
+		if err != nil || v == nil {
+			return nil, fmt.Errorf("cursor: invalid value for sort key: %s", key.Field)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// signCursor returns the signature of cursor data
+func (q *JSQ) signCursor(data string) string {
+	mac := hmac.New(sha256.New, q.cursorKey)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sortSignature returns a string that identifies sort keys
+func sortSignature(sort []SortKey) string {
+	keys := make([]string, len(sort))
+	for i, key := range sort {
+		keys[i] = key.Field
+		if key.Desc {
+			keys[i] += ":desc"
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// keysetSQL returns the predicate that selects the rows after the
+// cursor, with '?' placeholders, or an empty string if there is no
+// cursor. Dialects that support row values get a row comparison when
+// all keys sort in the same direction. Otherwise, the expanded form
+// (a > ?) OR (a = ? AND b > ?) is used.
+func (q *JSQ) keysetSQL() (string, []interface{}) {
+	sort, values := q.options.Sort, q.options.After
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	symbol := func(key SortKey) string {
+		if key.Desc {
+			return "<"
+		}
+		return ">"
+	}
+
+	sameDirection := true
+	columns := make([]string, len(sort))
+	for i, key := range sort {
+		columns[i] = q.column(key.Field)
+		sameDirection = sameDirection && key.Desc == sort[0].Desc
+	}
+
+	if len(sort) == 1 || (sameDirection && supportsRowValues(q.dialect)) {
+		placeHolders := strings.TrimRight(strings.Repeat("?, ", len(sort)), ", ")
+		if len(sort) == 1 {
+			return fmt.Sprintf("%s %s ?", columns[0], symbol(sort[0])), values
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), symbol(sort[0]), placeHolders), values
+	}
+
+	var branches []string
+	var args []interface{}
+	for i, key := range sort {
+		var conds []string
+		for j := 0; j < i; j++ {
+			conds = append(conds, fmt.Sprintf("%s = ?", columns[j]))
+			args = append(args, values[j])
+		}
+		conds = append(conds, fmt.Sprintf("%s %s ?", columns[i], symbol(key)))
+		args = append(args, values[i])
+		branches = append(branches, "("+strings.Join(conds, " AND ")+")")
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", args
+}
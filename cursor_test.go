@@ -0,0 +1,134 @@
+package jsq
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCursor(t *testing.T) {
+	Convey("Cursor", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"id":         {Type: TypeInt},
+			"created_at": {Type: TypeTimestamp},
+			"name":       {},
+		})
+		So(err, ShouldBeNil)
+		jsq.SetCursorKey([]byte("secret"))
+
+		err = jsq.ParseOptions(`{"sort": {"created_at": 1, "id": 1}, "limit": 10}`)
+		So(err, ShouldBeNil)
+		cursor, err := jsq.EncodeCursor(map[string]interface{}{"created_at": "2017-01-02T15:04:05Z", "id": 7})
+		So(err, ShouldBeNil)
+
+		Convey(".EncodeCursor", func() {
+			Convey("Should return error if cursor key is not set", func() {
+				jsq.SetCursorKey(nil)
+				_, err := jsq.EncodeCursor(map[string]interface{}{"created_at": "2017-01-02T15:04:05Z", "id": 7})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "cursor key is not set")
+			})
+
+			Convey("Should return error if a sort key value is missing", func() {
+				_, err := jsq.EncodeCursor(map[string]interface{}{"id": 7})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "cursor: missing value for sort key: created_at")
+			})
+
+			Convey("Should return error if a sort key sets nulls order", func() {
+				err := jsq.ParseOptions(`{"sort": {"created_at": {"order": 1, "nulls": "last"}, "id": 1}}`)
+				So(err, ShouldBeNil)
+				_, err = jsq.EncodeCursor(map[string]interface{}{"created_at": "2017-01-02T15:04:05Z", "id": 7})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "cursor: sort key 'created_at' cannot set nulls order")
+			})
+		})
+
+		Convey("Should use a row value comparison when keys sort in the same direction", func() {
+			err := jsq.ParseFind(`{"filter": {"name": "ben"}, "sort": {"created_at": 1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("name" = $1) AND ("created_at", "id") > ($2, $3)`)
+			So(args[2], ShouldEqual, int64(7))
+		})
+
+		Convey("Should use the expanded form on dialects without row values", func() {
+			err := jsq.ParseOptions(`{"sort": {"created_at": 1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQLFor(SQLServer)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(([created_at] > @p1) OR ([created_at] = @p2 AND [id] > @p3))`)
+			So(len(args), ShouldEqual, 3)
+		})
+
+		Convey("Should use the expanded form on dialects without DialectFeatures", func() {
+			err := jsq.ParseOptions(`{"sort": {"created_at": 1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.ToSQLFor(customDialect{Postgres})
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(("created_at" > $1) OR ("created_at" = $2 AND "id" > $3))`)
+		})
+
+		Convey("Should keep the precision of large integers", func() {
+			err := jsq.ParseOptions(`{"sort": {"id": 1}}`)
+			So(err, ShouldBeNil)
+			cursor, err := jsq.EncodeCursor(map[string]interface{}{"id": int64(9007199254740993)})
+			So(err, ShouldBeNil)
+			err = jsq.ParseOptions(`{"sort": {"id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"id" > $1`)
+			So(args, ShouldResemble, []interface{}{int64(9007199254740993)})
+		})
+
+		Convey("Should use the expanded form when keys sort in different directions", func() {
+			err := jsq.ParseOptions(`{"sort": {"created_at": -1, "id": 1}}`)
+			So(err, ShouldBeNil)
+			cursor, err := jsq.EncodeCursor(map[string]interface{}{"created_at": "2017-01-02T15:04:05Z", "id": 7})
+			So(err, ShouldBeNil)
+			err = jsq.ParseOptions(`{"sort": {"created_at": -1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.Select("posts")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "posts" WHERE (("created_at" < $1) OR ("created_at" = $2 AND "id" > $3)) ORDER BY "created_at" DESC, "id"`)
+		})
+
+		Convey("Should not apply a cursor to writes", func() {
+			jsq.AllowAnyField(true)
+			err := jsq.ParseFind(`{"sort": {"created_at": 1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldBeNil)
+			_, _, err = jsq.Delete("posts")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "delete: cursors are not supported")
+
+			So(jsq.ParseUpdate(`{"$set": {"name": "ben"}}`), ShouldBeNil)
+			_, _, err = jsq.Update("posts")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "update: cursors are not supported")
+		})
+
+		Convey("Should reject a tampered cursor", func() {
+			parts := strings.Split(cursor, ".")
+			err := jsq.ParseOptions(`{"sort": {"created_at": 1, "id": 1}, "cursor": "` + parts[0] + `x.` + parts[1] + `"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "cursor: invalid cursor")
+		})
+
+		Convey("Should reject a cursor created for another sort", func() {
+			err := jsq.ParseOptions(`{"sort": {"id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "cursor: sort does not match the cursor")
+		})
+
+		Convey("Should reject a cursor signed with another key", func() {
+			jsq.SetCursorKey([]byte("other"))
+			err := jsq.ParseOptions(`{"sort": {"created_at": 1, "id": 1}, "cursor": "` + cursor + `"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "cursor: invalid cursor")
+		})
+	})
+}
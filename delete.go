@@ -0,0 +1,171 @@
+package jsq
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Execer executes a statement. It is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// SetDeleteLimit caps the number of rows a DELETE statement
+// removes. A limit of zero or less removes the cap.
+func (q *JSQ) SetDeleteLimit(n int) {
+	q.deleteLimit = n
+}
+
+// Delete returns a DELETE statement that removes the rows of table
+// that match the parsed filter. It returns error if the filter is
+// empty or matches every row, unless unfiltered writes are allowed.
+// If a delete limit is set, at most that many rows are removed.
+func (q *JSQ) Delete(table string) (string, []interface{}, error) {
+	name, err := q.quoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scoped, err := q.writeScope(table)
+	if err != nil {
+		return "", nil, err
+	}
+	where, args, err := scoped.writeFilterSQL("delete")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if q.deleteLimit <= 0 {
+		return rebind(q.dialect, "DELETE FROM "+name+whereClause(where)), args, nil
+	}
+	stmt, ok := deleteLimitSQL(q.dialect, name, where, q.deleteLimit)
+	if !ok {
+		return "", nil, fmt.Errorf("delete limit is not supported by the %s dialect", q.dialect.Name())
+	}
+	return rebind(q.dialect, stmt), args, nil
+}
+
+// ExecDelete executes the statement returned by
+// Delete and returns the number of rows removed
+func (q *JSQ) ExecDelete(db Execer, table string) (int64, error) {
+	stmt, args, err := q.Delete(table)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// writeFilterSQL returns the filter of a write statement with '?'
+// placeholders. Cursors only page through rows, so they cannot
+// restrict the rows a write modifies.
+func (q *JSQ) writeFilterSQL(action string) (string, []interface{}, error) {
+	if len(q.options.After) > 0 {
+		return "", nil, fmt.Errorf("%s: cursors are not supported", action)
+	}
+	where, args, err := q.getSQL()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := q.checkFiltered(action, where); err != nil {
+		return "", nil, err
+	}
+	return where, args, nil
+}
+
+// checkFiltered returns error if a write statement would
+// modify every row, unless unfiltered writes are allowed
+func (q *JSQ) checkFiltered(action, where string) error {
+	if q.allowUnfilteredWrites {
+		return nil
+	}
+	if where == "" {
+		return fmt.Errorf("refusing to %s all rows: filter is empty", action)
+	}
+	if alwaysTrue(q.node) {
+		return fmt.Errorf("refusing to %s all rows: filter is always true", action)
+	}
+	return nil
+}
+
+// alwaysTrue checks whether a query AST matches every row.
+// Only trivial forms are detected: empty queries, empty $in
+// and $nin lists and logical operators, including negations,
+// that reduce to them.
+func alwaysTrue(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+
+	case And:
+		for _, entry := range n {
+			if !alwaysTrue(entry) {
+				return false
+			}
+		}
+		return true
+
+	case Or:
+		for _, entry := range n {
+			if alwaysTrue(entry) {
+				return true
+			}
+		}
+
+	case Nor:
+		for _, entry := range n {
+			if !alwaysFalse(entry) {
+				return false
+			}
+		}
+		return true
+
+	case Not:
+		return alwaysFalse(n.Node)
+
+	case Compare:
+		values, isArray := n.Value.([]interface{})
+		return n.Op == "$nin" && isArray && len(values) == 0
+	}
+	return false
+}
+
+// alwaysFalse checks whether a query AST matches no row. It
+// detects the negations of the forms detected by alwaysTrue.
+func alwaysFalse(node Node) bool {
+	switch n := node.(type) {
+	case And:
+		for _, entry := range n {
+			if alwaysFalse(entry) {
+				return true
+			}
+		}
+
+	case Or:
+		// an empty $or matches no row
+		for _, entry := range n {
+			if !alwaysFalse(entry) {
+				return false
+			}
+		}
+		return true
+
+	case Nor:
+		for _, entry := range n {
+			if alwaysTrue(entry) {
+				return true
+			}
+		}
+
+	case Not:
+		return alwaysTrue(n.Node)
+
+	case Compare:
+		values, isArray := n.Value.([]interface{})
+		return n.Op == "$in" && isArray && len(values) == 0
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package jsq
+
+import (
+	"database/sql"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// execResult is a sql.Result with a fixed row count
+type execResult int64
+
+func (r execResult) LastInsertId() (int64, error) { return 0, nil }
+func (r execResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+// recordExecer records the executed statement
+type recordExecer struct {
+	query string
+	args  []interface{}
+}
+
+func (e *recordExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	e.query, e.args = query, args
+	return execResult(3), nil
+}
+
+func TestDelete(t *testing.T) {
+	Convey("Delete", t, func() {
+		jsq := NewJSQWithDialect([]string{"name", "age"}, Postgres)
+
+		Convey("Should refuse to delete all rows if nothing was parsed", func() {
+			_, _, err := jsq.Delete("people")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "refusing to delete all rows: filter is empty")
+		})
+
+		Convey("Should refuse filters that are always true", func() {
+			for _, filter := range []string{
+				`{}`,
+				`{"$or": [{}]}`,
+				`{"$and": [{}]}`,
+				`{"$or": [{"name": "ben"}, {}]}`,
+				`{"age": {"$nin": []}}`,
+				`{"$nor": [{"age": {"$in": []}}]}`,
+				`{"$nor": [{"$nor": [{}]}]}`,
+				`{"age": {"$not": {"$in": []}}}`,
+				`{"$nor": [{"name": "ben", "age": {"$not": {"$nin": []}}}]}`,
+				`{"$nor": [{"$or": []}]}`,
+				`{"name": {"$not": {"$in": []}}, "$nor": [{"$or": []}]}`,
+			} {
+				err := jsq.Parse(filter)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Delete("people")
+				So(err, ShouldNotBeNil)
+			}
+			err := jsq.Parse(`{"age": {"$nin": []}}`)
+			So(err, ShouldBeNil)
+			_, _, err = jsq.Delete("people")
+			So(err.Error(), ShouldEqual, "refusing to delete all rows: filter is always true")
+		})
+
+		Convey("Should accept negations that are not always true", func() {
+			for _, filter := range []string{
+				`{"$nor": [{"name": "ben"}]}`,
+				`{"age": {"$not": {"$nin": []}}}`,
+				`{"$or": []}`,
+			} {
+				err := jsq.Parse(filter)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Delete("people")
+				So(err, ShouldBeNil)
+			}
+		})
+
+		Convey("Should delete all rows if unfiltered writes are allowed", func() {
+			jsq.AllowUnfilteredWrites(true)
+			err := jsq.Parse(`{}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.Delete("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `DELETE FROM "people"`)
+		})
+
+		Convey("Should generate a filtered statement", func() {
+			err := jsq.Parse(`{"$or": [{"name": "ben"}, {"age": {"$gt": 90}}]}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.Delete("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `DELETE FROM "people" WHERE ("name" = $1 OR "age" > $2)`)
+			So(args, ShouldResemble, []interface{}{"ben", 90.0})
+		})
+
+		Convey("Should not qualify the columns of qualified fields", func() {
+			err := jsq.SetFields(FieldMap{"id": {Table: "p", Type: TypeInt}})
+			So(err, ShouldBeNil)
+			err = jsq.Parse(`{"id": 7}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.Delete("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `DELETE FROM "people" WHERE "id" = $1`)
+			So(args, ShouldResemble, []interface{}{int64(7)})
+
+			jsq.SetDeleteLimit(10)
+			sql, _, err = jsq.Delete("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `DELETE FROM "people" WHERE ctid IN (SELECT ctid FROM "people" WHERE "id" = $1 LIMIT 10)`)
+		})
+
+		Convey("Should cap the number of rows per dialect", func() {
+			err := jsq.Parse(`{"name": "ben"}`)
+			So(err, ShouldBeNil)
+			jsq.SetDeleteLimit(10)
+
+			expected := map[Dialect]string{
+				Postgres:    `DELETE FROM "people" WHERE ctid IN (SELECT ctid FROM "people" WHERE "name" = $1 LIMIT 10)`,
+				CockroachDB: `DELETE FROM "people" WHERE "name" = $1 LIMIT 10`,
+				MySQL:       "DELETE FROM `people` WHERE `name` = ? LIMIT 10",
+				SQLite:      `DELETE FROM "people" WHERE rowid IN (SELECT rowid FROM "people" WHERE "name" = ? LIMIT 10)`,
+				SQLServer:   `DELETE TOP (10) FROM [people] WHERE [name] = @p1`,
+				Oracle:      `DELETE FROM "people" WHERE ("name" = :1) AND ROWNUM <= 10`,
+			}
+			for d, stmt := range expected {
+				jsq.dialect = d
+				err := jsq.Parse(`{"name": "ben"}`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Delete("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, stmt)
+			}
+
+			jsq.dialect = Generic
+			_, _, err = jsq.Delete("people")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "delete limit is not supported by the generic dialect")
+		})
+
+		Convey("Should execute and return the affected row count", func() {
+			err := jsq.Parse(`{"name": "ben"}`)
+			So(err, ShouldBeNil)
+			db := &recordExecer{}
+			n, err := jsq.ExecDelete(db, "people")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(db.query, ShouldEqual, `DELETE FROM "people" WHERE "name" = $1`)
+			So(db.args, ShouldResemble, []interface{}{"ben"})
+		})
+	})
+}
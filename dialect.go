@@ -0,0 +1,856 @@
+package jsq
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes how SQL is written for a
+// specific database server
+type Dialect interface {
+
+	// Name returns the name of the dialect
+	Name() string
+
+	// Placeholder returns the bind parameter marker
+	// for the nth (1-based) argument
+	Placeholder(n int) string
+
+	// QuoteIdent quotes an identifier such as a column name
+	QuoteIdent(ident string) string
+
+	// Like returns an expression that matches column against
+	// a single bind parameter. If insensitive is true, the
+	// match must ignore case. Pattern characters escaped by
+	// EscapeLike must be matched literally.
+	Like(column string, insensitive bool) string
+
+	// EqualFold returns an expression that checks whether column
+	// equals a single bind parameter, ignoring case
+	EqualFold(column string) string
+
+	// EscapeLike escapes the wildcard and escape characters
+	// in a string so that it is matched literally by Like
+	EscapeLike(s string) string
+
+	// Regex returns an expression that matches column against a
+	// regular expression in a single bind parameter, and the value
+	// to bind. It returns error if the dialect cannot execute the
+	// pattern. The pattern is a valid RE2 expression.
+	Regex(column, pattern string, insensitive bool) (string, string, error)
+
+	// OrderBy returns a sort key. nulls is "first", "last"
+	// or empty for the default null ordering of the database.
+	OrderBy(column string, desc bool, nulls string) string
+
+	// LimitOffset returns the clause that limits the number of
+	// rows and skips rows. A limit of zero means no limit. It
+	// returns an empty string if there is no limit and no offset.
+	LimitOffset(limit, offset int) string
+
+	// Bool returns the literal representation of a boolean
+	Bool(v bool) string
+
+	// Concat returns an expression that concatenates exprs
+	Concat(exprs ...string) string
+}
+
+// DialectFeatures is an optional interface of a Dialect for the
+// SQL that differs the most between databases. The built-in dialects
+// implement it. With a custom Dialect that does not, operations that
+// need one of these features return an error.
+type DialectFeatures interface {
+
+	// RowValues reports whether row values can be
+	// compared, e.g. (a, b) > (?, ?)
+	RowValues() bool
+
+	// OffsetNeedsOrder reports whether an offset is only
+	// accepted in a statement with an ORDER BY
+	OffsetNeedsOrder() bool
+
+	// DeleteLimit returns a DELETE statement that removes at most
+	// limit rows of table matching where. It returns false if the
+	// rows of a DELETE cannot be limited.
+	DeleteLimit(table, where string, limit int) (string, bool)
+
+	// Upsert returns how an upsert statement is written: UpsertOnConflict,
+	// UpsertOnDuplicateKey, UpsertMerge or an empty string if upserts
+	// are not supported
+	Upsert() string
+
+	// JSONPath returns an expression that extracts the scalar at a
+	// path of a JSON column as text. It returns false if JSON paths
+	// are not supported.
+	JSONPath(column string, path []string) (string, bool)
+
+	// JSONBool returns the value a JSON boolean
+	// extracted by JSONPath is compared with
+	JSONBool(v bool) interface{}
+
+	// CastNumeric returns an expression that converts expr to a number
+	CastNumeric(expr string) string
+
+	// Arrays reports whether the operators of array
+	// and JSON array columns are supported
+	Arrays() bool
+
+	// Mod returns an expression for the remainder of a divided by b
+	Mod(a, b string) string
+
+	// DateDiff returns an expression for the number of milliseconds
+	// from date b to date a. It returns false if dates cannot be
+	// subtracted.
+	DateDiff(a, b string) (string, bool)
+
+	// ArrayAgg returns an aggregate that collects the values of expr
+	// into an array. It returns false if there is no such aggregate.
+	ArrayAgg(expr string) (string, bool)
+}
+
+// dialect is a table driven implementation of Dialect
+type dialect struct {
+	name        string
+	placeholder func(n int) string
+	quoteOpen   string
+	quoteClose  string
+	ilike       bool
+	nocase      bool
+	likeEscape  string
+	likeSpecial string
+	regex       func(column, pattern string, insensitive bool) (string, string)
+	regexReject []string
+	nullsOrder  bool
+	rowValues   bool
+	offsetOrder bool
+	limit       func(limit, offset int) string
+	boolTrue    string
+	boolFalse   string
+	concat      func(exprs []string) string
+	deleteLimit func(table, where string, limit int) string
+	upsert      string
+	arrayAgg    string
+	jsonPath    func(column string, path []string) string
+	jsonBoolInt bool
+	numericType string
+	arrays      bool
+	mod         func(a, b string) string
+	dateDiff    func(a, b string) string
+}
+
+var (
+	// Generic emits '?' placeholders and leaves identifiers unquoted.
+	// It is the default dialect and matches the output of go-xorm/builder.
+	Generic Dialect = &dialect{
+		name:        "generic",
+		placeholder: questionPlaceholder,
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_`,
+		nullsOrder:  true,
+		limit:       limitOffset,
+		boolTrue:    "TRUE",
+		boolFalse:   "FALSE",
+		concat:      pipeConcat,
+		arrayAgg:    "ARRAY_AGG",
+		jsonPath:    jsonValuePath,
+		numericType: "NUMERIC",
+	}
+
+	// Postgres targets PostgreSQL
+	Postgres Dialect = &dialect{
+		name:        "postgres",
+		placeholder: dollarPlaceholder,
+		quoteOpen:   `"`,
+		quoteClose:  `"`,
+		ilike:       true,
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_`,
+		regex:       postgresRegex,
+		regexReject: aregexReject,
+		nullsOrder:  true,
+		rowValues:   true,
+		limit:       limitOffset,
+		boolTrue:    "TRUE",
+		boolFalse:   "FALSE",
+		concat:      pipeConcat,
+		deleteLimit: ctidDeleteLimit,
+		upsert:      UpsertOnConflict,
+		arrayAgg:    "json_agg",
+		jsonPath:    postgresJSONPath,
+		numericType: "NUMERIC",
+		arrays:      true,
+		dateDiff:    epochDateDiff,
+	}
+
+	// CockroachDB targets CockroachDB
+	CockroachDB Dialect = &dialect{
+		name:        "cockroachdb",
+		placeholder: dollarPlaceholder,
+		quoteOpen:   `"`,
+		quoteClose:  `"`,
+		ilike:       true,
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_`,
+		regex:       postgresRegex,
+		regexReject: aregexReject,
+		nullsOrder:  true,
+		rowValues:   true,
+		limit:       limitOffset,
+		boolTrue:    "TRUE",
+		boolFalse:   "FALSE",
+		concat:      pipeConcat,
+		deleteLimit: trailingDeleteLimit,
+		upsert:      UpsertOnConflict,
+		arrayAgg:    "json_agg",
+		jsonPath:    postgresJSONPath,
+		numericType: "NUMERIC",
+		arrays:      true,
+		dateDiff:    epochDateDiff,
+	}
+
+	// MySQL targets MySQL and MariaDB
+	MySQL Dialect = &dialect{
+		name:        "mysql",
+		placeholder: questionPlaceholder,
+		quoteOpen:   "`",
+		quoteClose:  "`",
+		likeEscape:  `'\\'`,
+		likeSpecial: `\%_`,
+		regex:       mysqlRegex,
+		regexReject: []string{"(?P<", "(?U"},
+		rowValues:   true,
+		limit:       mysqlLimit,
+		boolTrue:    "TRUE",
+		boolFalse:   "FALSE",
+		concat:      funcConcat,
+		deleteLimit: trailingDeleteLimit,
+		upsert:      UpsertOnDuplicateKey,
+		arrayAgg:    "JSON_ARRAYAGG",
+		jsonPath:    mysqlJSONPath,
+		numericType: "DECIMAL(65,30)",
+		dateDiff:    mysqlDateDiff,
+	}
+
+	// SQLite targets SQLite 3
+	SQLite Dialect = &dialect{
+		name:        "sqlite",
+		placeholder: questionPlaceholder,
+		quoteOpen:   `"`,
+		quoteClose:  `"`,
+		nocase:      true,
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_`,
+		regex:       sqliteRegex,
+		nullsOrder:  true,
+		rowValues:   true,
+		limit:       sqliteLimit,
+		boolTrue:    "1",
+		boolFalse:   "0",
+		concat:      pipeConcat,
+		deleteLimit: rowidDeleteLimit,
+		upsert:      UpsertOnConflict,
+		arrayAgg:    "json_group_array",
+		jsonPath:    sqliteJSONPath,
+		jsonBoolInt: true,
+		numericType: "REAL",
+		mod:         percentMod,
+		dateDiff:    sqliteDateDiff,
+	}
+
+	// SQLServer targets Microsoft SQL Server
+	SQLServer Dialect = &dialect{
+		name: "sqlserver",
+		placeholder: func(n int) string {
+			return fmt.Sprintf("@p%d", n)
+		},
+		quoteOpen:   "[",
+		quoteClose:  "]",
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_[`,
+		offsetOrder: true,
+		limit:       fetchLimit,
+		boolTrue:    "1",
+		boolFalse:   "0",
+		concat: func(exprs []string) string {
+			return "(" + strings.Join(exprs, " + ") + ")"
+		},
+		deleteLimit: topDeleteLimit,
+		upsert:      UpsertMerge,
+		jsonPath:    jsonValuePath,
+		numericType: "FLOAT",
+		mod:         percentMod,
+		dateDiff:    sqlserverDateDiff,
+	}
+
+	// Oracle targets Oracle Database
+	Oracle Dialect = &dialect{
+		name: "oracle",
+		placeholder: func(n int) string {
+			return fmt.Sprintf(":%d", n)
+		},
+		quoteOpen:   `"`,
+		quoteClose:  `"`,
+		likeEscape:  `'\'`,
+		likeSpecial: `\%_`,
+		regex:       oracleRegex,
+		regexReject: aregexReject,
+		nullsOrder:  true,
+		limit:       fetchLimit,
+		boolTrue:    "1",
+		boolFalse:   "0",
+		concat:      pipeConcat,
+		deleteLimit: rownumDeleteLimit,
+		arrayAgg:    "JSON_ARRAYAGG",
+		jsonPath:    jsonValuePath,
+		numericType: "NUMBER",
+		dateDiff:    oracleDateDiff,
+	}
+)
+
+// jsonPathString returns a standard SQL/JSON path such as $.a.b[0]
+func jsonPathString(path []string) string {
+	var buf bytes.Buffer
+	buf.WriteString("$")
+	for _, key := range path {
+		if isJSONIndex(key) {
+			buf.WriteString("[" + key + "]")
+			continue
+		}
+		buf.WriteString("." + key)
+	}
+	return buf.String()
+}
+
+// postgresJSONPath uses the -> operators, so that
+// the value at the path is returned as text
+func postgresJSONPath(column string, path []string) string {
+	expr := column
+	for i, key := range path {
+		op := "->"
+		if i == len(path)-1 {
+			op = "->>"
+		}
+		if !isJSONIndex(key) {
+			key = "'" + key + "'"
+		}
+		expr += op + key
+	}
+	return "(" + expr + ")"
+}
+
+func mysqlJSONPath(column string, path []string) string {
+	return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, '%s'))", column, jsonPathString(path))
+}
+
+func sqliteJSONPath(column string, path []string) string {
+	return fmt.Sprintf("json_extract(%s, '%s')", column, jsonPathString(path))
+}
+
+func jsonValuePath(column string, path []string) string {
+	return fmt.Sprintf("JSON_VALUE(%s, '%s')", column, jsonPathString(path))
+}
+
+// Upsert statement styles returned by DialectFeatures.Upsert
+const (
+	// UpsertOnConflict writes INSERT ... ON CONFLICT ... DO UPDATE
+	UpsertOnConflict = "conflict"
+
+	// UpsertOnDuplicateKey writes INSERT ... ON DUPLICATE KEY UPDATE
+	UpsertOnDuplicateKey = "duplicate"
+
+	// UpsertMerge writes a MERGE statement
+	UpsertMerge = "merge"
+)
+
+// aregexReject holds RE2 constructs that are not supported
+// by the advanced regular expressions of Postgres and Oracle
+var aregexReject = []string{"(?P<", "(?i", "(?m", "(?s", "(?U"}
+
+func postgresRegex(column, pattern string, insensitive bool) (string, string) {
+	if insensitive {
+		return fmt.Sprintf("%s ~* ?", column), pattern
+	}
+	return fmt.Sprintf("%s ~ ?", column), pattern
+}
+
+// mysqlRegex uses the REGEXP operator, as MariaDB has no REGEXP_LIKE.
+// Its case sensitivity depends on the collation of the column, so
+// it is set with a flag in the pattern, which both ICU on MySQL 8
+// and PCRE on MariaDB accept.
+func mysqlRegex(column, pattern string, insensitive bool) (string, string) {
+	if insensitive {
+		return fmt.Sprintf("%s REGEXP ?", column), "(?i)" + pattern
+	}
+	return fmt.Sprintf("%s REGEXP ?", column), "(?-i)" + pattern
+}
+
+// sqliteRegex relies on a REGEXP function registered with the
+// connection. Such functions are expected to use Go's regexp package,
+// so case-insensitivity is expressed with a flag in the pattern.
+func sqliteRegex(column, pattern string, insensitive bool) (string, string) {
+	if insensitive {
+		pattern = "(?i)" + pattern
+	}
+	return fmt.Sprintf("%s REGEXP ?", column), pattern
+}
+
+func oracleRegex(column, pattern string, insensitive bool) (string, string) {
+	if insensitive {
+		return fmt.Sprintf("REGEXP_LIKE(%s, ?, 'i')", column), pattern
+	}
+	return fmt.Sprintf("REGEXP_LIKE(%s, ?, 'c')", column), pattern
+}
+
+func limitOffset(limit, offset int) string {
+	var clauses []string
+	if limit > 0 {
+		clauses = append(clauses, fmt.Sprintf("LIMIT %d", limit))
+	}
+	if offset > 0 {
+		clauses = append(clauses, fmt.Sprintf("OFFSET %d", offset))
+	}
+	return strings.Join(clauses, " ")
+}
+
+// mysqlLimit uses the largest row count to skip
+// rows without a limit, as MySQL requires a limit
+func mysqlLimit(limit, offset int) string {
+	if limit == 0 && offset > 0 {
+		return fmt.Sprintf("LIMIT 18446744073709551615 OFFSET %d", offset)
+	}
+	return limitOffset(limit, offset)
+}
+
+// sqliteLimit uses a negative limit to skip
+// rows without a limit, as SQLite requires a limit
+func sqliteLimit(limit, offset int) string {
+	if limit == 0 && offset > 0 {
+		return fmt.Sprintf("LIMIT -1 OFFSET %d", offset)
+	}
+	return limitOffset(limit, offset)
+}
+
+// fetchLimit uses the standard OFFSET/FETCH clause.
+// SQL Server requires the statement to have an ORDER BY.
+func fetchLimit(limit, offset int) string {
+	if limit == 0 && offset == 0 {
+		return ""
+	}
+	clause := fmt.Sprintf("OFFSET %d ROWS", offset)
+	if limit > 0 {
+		clause += fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit)
+	}
+	return clause
+}
+
+// whereClause returns a WHERE clause or an empty
+// string if there is no condition
+func whereClause(where string) string {
+	if where == "" {
+		return ""
+	}
+	return " WHERE " + where
+}
+
+// trailingDeleteLimit appends a LIMIT clause to the statement
+func trailingDeleteLimit(table, where string, limit int) string {
+	return fmt.Sprintf("DELETE FROM %s%s LIMIT %d", table, whereClause(where), limit)
+}
+
+// ctidDeleteLimit selects the rows to delete by their physical
+// location, as Postgres does not accept a LIMIT in a DELETE
+func ctidDeleteLimit(table, where string, limit int) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE ctid IN (SELECT ctid FROM %s%s LIMIT %d)", table, table, whereClause(where), limit)
+}
+
+// rowidDeleteLimit selects the rows to delete by rowid, as SQLite
+// only accepts a LIMIT in a DELETE when built with an option
+func rowidDeleteLimit(table, where string, limit int) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s%s LIMIT %d)", table, table, whereClause(where), limit)
+}
+
+func topDeleteLimit(table, where string, limit int) string {
+	return fmt.Sprintf("DELETE TOP (%d) FROM %s%s", limit, table, whereClause(where))
+}
+
+func rownumDeleteLimit(table, where string, limit int) string {
+	if where == "" {
+		return fmt.Sprintf("DELETE FROM %s WHERE ROWNUM <= %d", table, limit)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE (%s) AND ROWNUM <= %d", table, where, limit)
+}
+
+func percentMod(a, b string) string {
+	return fmt.Sprintf("(%s %% %s)", a, b)
+}
+
+func epochDateDiff(a, b string) string {
+	return fmt.Sprintf("((EXTRACT(EPOCH FROM %s) - EXTRACT(EPOCH FROM %s)) * 1000)", a, b)
+}
+
+func mysqlDateDiff(a, b string) string {
+	return fmt.Sprintf("(TIMESTAMPDIFF(MICROSECOND, %s, %s) / 1000)", b, a)
+}
+
+func sqliteDateDiff(a, b string) string {
+	return fmt.Sprintf("((julianday(%s) - julianday(%s)) * 86400000)", a, b)
+}
+
+func sqlserverDateDiff(a, b string) string {
+	return fmt.Sprintf("DATEDIFF_BIG(millisecond, %s, %s)", b, a)
+}
+
+func oracleDateDiff(a, b string) string {
+	return fmt.Sprintf("((CAST(%s AS DATE) - CAST(%s AS DATE)) * 86400000)", a, b)
+}
+
+func questionPlaceholder(n int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func pipeConcat(exprs []string) string {
+	return "(" + strings.Join(exprs, " || ") + ")"
+}
+
+func funcConcat(exprs []string) string {
+	return "CONCAT(" + strings.Join(exprs, ", ") + ")"
+}
+
+// Name returns the name of the dialect
+func (d *dialect) Name() string {
+	return d.name
+}
+
+// Placeholder returns the bind parameter marker for the nth argument
+func (d *dialect) Placeholder(n int) string {
+	return d.placeholder(n)
+}
+
+// QuoteIdent quotes an identifier. Occurrences of the closing
+// quote character within the identifier are doubled.
+func (d *dialect) QuoteIdent(ident string) string {
+	if d.quoteOpen == "" {
+		return ident
+	}
+	return d.quoteOpen + strings.Replace(ident, d.quoteClose, d.quoteClose+d.quoteClose, -1) + d.quoteClose
+}
+
+// Like returns a pattern match expression with a backslash
+// escape clause. Dialects without ILIKE lower both sides for
+// case-insensitive matches.
+func (d *dialect) Like(column string, insensitive bool) string {
+	if !insensitive {
+		return fmt.Sprintf("%s LIKE ? ESCAPE %s", column, d.likeEscape)
+	}
+	if d.ilike {
+		return fmt.Sprintf("%s ILIKE ? ESCAPE %s", column, d.likeEscape)
+	}
+	return fmt.Sprintf("LOWER(%s) LIKE LOWER(?) ESCAPE %s", column, d.likeEscape)
+}
+
+// EqualFold returns a case-insensitive equality expression. It
+// uses the NOCASE collation where available and lowers both
+// sides otherwise.
+func (d *dialect) EqualFold(column string) string {
+	if d.nocase {
+		return fmt.Sprintf("%s = ? COLLATE NOCASE", column)
+	}
+	return fmt.Sprintf("LOWER(%s) = LOWER(?)", column)
+}
+
+// EscapeLike prefixes the special pattern characters
+// of the dialect with a backslash
+func (d *dialect) EscapeLike(s string) string {
+	var buf bytes.Buffer
+	for _, c := range s {
+		if strings.ContainsRune(d.likeSpecial, c) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// Regex returns a regular expression match. It returns
+// error if the dialect has no regular expression support
+// or does not support a construct in the pattern.
+func (d *dialect) Regex(column, pattern string, insensitive bool) (string, string, error) {
+	if d.regex == nil {
+		return "", "", fmt.Errorf("regular expressions are not supported by the %s dialect", d.name)
+	}
+	for _, construct := range d.regexReject {
+		if strings.Contains(pattern, construct) {
+			return "", "", fmt.Errorf("pattern construct '%s' is not supported by the %s dialect", construct, d.name)
+		}
+	}
+	expr, arg := d.regex(column, pattern, insensitive)
+	return expr, arg, nil
+}
+
+// OrderBy returns a sort key. Dialects without NULLS FIRST/LAST
+// sort on whether the column is null first.
+func (d *dialect) OrderBy(column string, desc bool, nulls string) string {
+	key := column
+	if desc {
+		key += " DESC"
+	}
+	if nulls == "" {
+		return key
+	}
+	if d.nullsOrder {
+		return fmt.Sprintf("%s NULLS %s", key, strings.ToUpper(nulls))
+	}
+	if nulls == "first" {
+		return fmt.Sprintf("CASE WHEN %s IS NULL THEN 0 ELSE 1 END, %s", column, key)
+	}
+	return fmt.Sprintf("CASE WHEN %s IS NULL THEN 1 ELSE 0 END, %s", column, key)
+}
+
+// LimitOffset returns the limit and offset clause
+func (d *dialect) LimitOffset(limit, offset int) string {
+	return d.limit(limit, offset)
+}
+
+// RowValues reports whether row values can be compared
+func (d *dialect) RowValues() bool {
+	return d.rowValues
+}
+
+// OffsetNeedsOrder reports whether an offset requires an ORDER BY
+func (d *dialect) OffsetNeedsOrder() bool {
+	return d.offsetOrder
+}
+
+// DeleteLimit returns a DELETE statement with a row limit
+func (d *dialect) DeleteLimit(table, where string, limit int) (string, bool) {
+	if d.deleteLimit == nil {
+		return "", false
+	}
+	return d.deleteLimit(table, where, limit), true
+}
+
+// Upsert returns the upsert statement style
+func (d *dialect) Upsert() string {
+	return d.upsert
+}
+
+// ArrayAgg returns an aggregate that collects values into an array
+func (d *dialect) ArrayAgg(expr string) (string, bool) {
+	if d.arrayAgg == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s(%s)", d.arrayAgg, expr), true
+}
+
+// JSONPath returns an expression that extracts a JSON path as text
+func (d *dialect) JSONPath(column string, path []string) (string, bool) {
+	if d.jsonPath == nil {
+		return "", false
+	}
+	return d.jsonPath(column, path), true
+}
+
+// JSONBool returns the value a JSON boolean is compared with
+func (d *dialect) JSONBool(v bool) interface{} {
+	if !d.jsonBoolInt {
+		return strconv.FormatBool(v)
+	}
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// CastNumeric returns an expression that converts expr to a number
+func (d *dialect) CastNumeric(expr string) string {
+	numericType := d.numericType
+	if numericType == "" {
+		numericType = "NUMERIC"
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", expr, numericType)
+}
+
+// Arrays reports whether array fields are supported
+func (d *dialect) Arrays() bool {
+	return d.arrays
+}
+
+// Mod returns a remainder expression
+func (d *dialect) Mod(a, b string) string {
+	if d.mod != nil {
+		return d.mod(a, b)
+	}
+	return fmt.Sprintf("MOD(%s, %s)", a, b)
+}
+
+// DateDiff returns the milliseconds between two dates
+func (d *dialect) DateDiff(a, b string) (string, bool) {
+	if d.dateDiff == nil {
+		return "", false
+	}
+	return d.dateDiff(a, b), true
+}
+
+// supportsRowValues checks whether a dialect can compare row values,
+// e.g. (a, b) > (?, ?). Without DialectFeatures, sort keys are
+// compared one by one, which is equivalent.
+func supportsRowValues(d Dialect) bool {
+	f, ok := d.(DialectFeatures)
+	return ok && f.RowValues()
+}
+
+// supportsArrays checks whether a dialect supports the
+// operators of array and JSON array columns
+func supportsArrays(d Dialect) bool {
+	f, ok := d.(DialectFeatures)
+	return ok && f.Arrays()
+}
+
+// offsetNeedsOrder checks whether a dialect only accepts an offset
+// in a statement with an ORDER BY. Without DialectFeatures, the
+// clause returned by LimitOffset is assumed to stand on its own.
+func offsetNeedsOrder(d Dialect) bool {
+	f, ok := d.(DialectFeatures)
+	return ok && f.OffsetNeedsOrder()
+}
+
+// deleteLimitSQL returns a DELETE statement that removes at most
+// limit rows of table matching where. It returns false if the
+// dialect cannot limit the rows of a DELETE.
+func deleteLimitSQL(d Dialect, table, where string, limit int) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.DeleteLimit(table, where, limit)
+}
+
+// upsertStyle returns how a dialect writes an upsert statement
+// or an empty string if upserts are not supported
+func upsertStyle(d Dialect) string {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return ""
+	}
+	return f.Upsert()
+}
+
+// jsonPath returns an expression that extracts the scalar at a path
+// of a JSON column as text, or as an SQL value on SQLite. It returns
+// false if the dialect does not support JSON paths.
+func jsonPath(d Dialect, column string, path []string) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.JSONPath(column, path)
+}
+
+// jsonBool returns the value a JSON boolean extracted by jsonPath
+// is compared with. Only dialects with JSON paths compare them.
+func jsonBool(d Dialect, v bool) interface{} {
+	if f, ok := d.(DialectFeatures); ok {
+		return f.JSONBool(v)
+	}
+	return strconv.FormatBool(v)
+}
+
+// castNumeric returns an expression that converts expr to a number.
+// It returns false if the dialect does not implement DialectFeatures.
+func castNumeric(d Dialect, expr string) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.CastNumeric(expr), true
+}
+
+// modSQL returns an expression for the remainder of a divided by b.
+// It returns false if the dialect does not implement DialectFeatures.
+func modSQL(d Dialect, a, b string) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.Mod(a, b), true
+}
+
+// dateDiff returns an expression for the number of milliseconds
+// from date b to date a. It returns false if the dialect cannot
+// subtract dates.
+func dateDiff(d Dialect, a, b string) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.DateDiff(a, b)
+}
+
+// arrayAgg returns an aggregate that collects the values of expr
+// into an array. It returns false if the dialect has no such aggregate.
+func arrayAgg(d Dialect, expr string) (string, bool) {
+	f, ok := d.(DialectFeatures)
+	if !ok {
+		return "", false
+	}
+	return f.ArrayAgg(expr)
+}
+
+// Bool returns the boolean literal
+func (d *dialect) Bool(v bool) string {
+	if v {
+		return d.boolTrue
+	}
+	return d.boolFalse
+}
+
+// Concat returns a string concatenation expression
+func (d *dialect) Concat(exprs ...string) string {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return d.concat(exprs)
+}
+
+// rebind replaces the '?' placeholders emitted by go-xorm/builder
+// with the placeholders of the dialect. Question marks within string
+// literals and quoted identifiers are left untouched.
+func rebind(d Dialect, sql string) string {
+	if d == nil || d.Placeholder(1) == "?" {
+		return sql
+	}
+
+	// derive the identifier quote characters from the dialect
+	var identOpen, identClose rune
+	if q := []rune(d.QuoteIdent("")); len(q) == 2 {
+		identOpen, identClose = q[0], q[1]
+	}
+
+	var buf bytes.Buffer
+	var closing rune
+	n := 0
+	for _, c := range sql {
+		switch {
+		case closing != 0:
+			if c == closing {
+				closing = 0
+			}
+		case c == '\'':
+			closing = c
+		case identOpen != 0 && c == identOpen:
+			closing = identClose
+		case c == '?':
+			n++
+			buf.WriteString(d.Placeholder(n))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
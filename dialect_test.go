@@ -0,0 +1,174 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDialect(t *testing.T) {
+	Convey("Dialect", t, func() {
+
+		Convey(".QuoteIdent", func() {
+			So(Generic.QuoteIdent("name"), ShouldEqual, "name")
+			So(Postgres.QuoteIdent("name"), ShouldEqual, `"name"`)
+			So(Postgres.QuoteIdent(`na"me`), ShouldEqual, `"na""me"`)
+			So(MySQL.QuoteIdent("name"), ShouldEqual, "`name`")
+			So(SQLServer.QuoteIdent("name"), ShouldEqual, "[name]")
+		})
+
+		Convey(".Placeholder", func() {
+			So(Generic.Placeholder(2), ShouldEqual, "?")
+			So(Postgres.Placeholder(2), ShouldEqual, "$2")
+			So(SQLServer.Placeholder(2), ShouldEqual, "@p2")
+			So(Oracle.Placeholder(2), ShouldEqual, ":2")
+		})
+
+		Convey(".Like", func() {
+			So(Postgres.Like(`"name"`, true), ShouldEqual, `"name" ILIKE ? ESCAPE '\'`)
+			So(MySQL.Like("`name`", true), ShouldEqual, "LOWER(`name`) LIKE LOWER(?) ESCAPE '\\\\'")
+			So(SQLite.Like(`"name"`, false), ShouldEqual, `"name" LIKE ? ESCAPE '\'`)
+		})
+
+		Convey(".EqualFold", func() {
+			So(Postgres.EqualFold(`"name"`), ShouldEqual, `LOWER("name") = LOWER(?)`)
+			So(SQLite.EqualFold(`"name"`), ShouldEqual, `"name" = ? COLLATE NOCASE`)
+		})
+
+		Convey(".Regex", func() {
+			sql, pattern, err := MySQL.Regex("`name`", "^ben", false)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "`name` REGEXP ?")
+			So(pattern, ShouldEqual, "(?-i)^ben")
+			_, pattern, err = MySQL.Regex("`name`", "^ben", true)
+			So(err, ShouldBeNil)
+			So(pattern, ShouldEqual, "(?i)^ben")
+		})
+
+		Convey(".EscapeLike", func() {
+			So(Postgres.EscapeLike(`report_2024 100% \ done`), ShouldEqual, `report\_2024 100\% \\ done`)
+			So(SQLServer.EscapeLike(`[a]_`), ShouldEqual, `\[a]\_`)
+		})
+
+		Convey(".Bool", func() {
+			So(Postgres.Bool(true), ShouldEqual, "TRUE")
+			So(SQLite.Bool(false), ShouldEqual, "0")
+		})
+
+		Convey(".Concat", func() {
+			So(Postgres.Concat("a", "b"), ShouldEqual, "(a || b)")
+			So(MySQL.Concat("a", "b"), ShouldEqual, "CONCAT(a, b)")
+			So(SQLServer.Concat("a", "b"), ShouldEqual, "(a + b)")
+		})
+
+		Convey("rebind", func() {
+			So(rebind(Postgres, `"a?" = ? AND b = '?' AND c = ?`), ShouldEqual, `"a?" = $1 AND b = '?' AND c = $2`)
+			So(rebind(SQLServer, `[a?] = ? AND b = ?`), ShouldEqual, `[a?] = @p1 AND b = @p2`)
+			So(rebind(MySQL, "a = ?"), ShouldEqual, "a = ?")
+		})
+
+		Convey("JSQ with a dialect", func() {
+			jsq := NewJSQWithDialect([]string{"name", "age", "reg_num"}, Postgres)
+			err := jsq.Parse(`{"name": { "$sw": "be" }, "age": { "$in": [21, 23] }, "reg_num": { "$gt": 3000 }}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `"name" LIKE $`)
+			So(sql, ShouldContainSubstring, `"age" IN ($`)
+			So(sql, ShouldContainSubstring, `"reg_num" > $`)
+			So(sql, ShouldNotContainSubstring, "?")
+			So(len(args), ShouldEqual, 4)
+
+			Convey(".ToSQLFor generates SQL for another dialect", func() {
+				sql, args, err := jsq.ToSQLFor(SQLServer)
+				So(err, ShouldBeNil)
+				So(sql, ShouldContainSubstring, "[name] LIKE @p")
+				So(sql, ShouldContainSubstring, "@p4")
+				So(len(args), ShouldEqual, 4)
+			})
+		})
+
+		Convey("JSQ rejects unsafe field names", func() {
+			jsq := NewJSQWithDialect(nil, Postgres)
+			jsq.AllowAnyField(true)
+			err := jsq.Parse(`{"1=1 OR name": "x"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: 1=1 OR name")
+
+			err = jsq.Parse(`{"name": "x"}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `"name" = $1`)
+		})
+
+		Convey("JSQ with a dialect without DialectFeatures", func() {
+			custom := customDialect{Postgres}
+			jsq := NewJSQWithDialect(nil, custom)
+			err := jsq.SetFields(FieldMap{
+				"name": {Type: TypeString},
+				"age":  {Type: TypeInt},
+				"meta": {Type: TypeJSON},
+				"tags": {Type: TypeArray, Items: TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Should not implement DialectFeatures", func() {
+				_, ok := interface{}(custom).(DialectFeatures)
+				So(ok, ShouldBeFalse)
+				_, ok = Postgres.(DialectFeatures)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Should reject json paths", func() {
+				err := jsq.Parse(`{"meta.color": "red"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'meta.color': json paths are not supported by the custom dialect")
+			})
+
+			Convey("Should reject array operators", func() {
+				err := jsq.Parse(`{"tags": {"$all": ["a"]}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'tags': array fields are not supported by the custom dialect")
+			})
+
+			Convey("Should reject $mod and $divide expressions", func() {
+				err := jsq.Parse(`{"$expr": {"$eq": [{"$mod": ["$age", 2]}, 0]}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$expr: '$mod' operator is not supported by the custom dialect")
+
+				err = jsq.Parse(`{"$expr": {"$gt": [{"$divide": ["$age", 2]}, 10]}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$expr: '$divide' operator is not supported by the custom dialect")
+			})
+
+			Convey("Should reject a delete limit", func() {
+				err := jsq.Parse(`{"name": "ben"}`)
+				So(err, ShouldBeNil)
+				jsq.SetDeleteLimit(10)
+				_, _, err = jsq.Delete("users")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "delete limit is not supported by the custom dialect")
+			})
+
+			Convey("Should still build plain filters", func() {
+				err := jsq.Parse(`{"name": "ben", "age": {"$gt": 21}}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"name" = $1 AND "age" > $2`)
+				So(args, ShouldResemble, []interface{}{"ben", int64(21)})
+			})
+		})
+	})
+}
+
+// customDialect is a user-supplied Dialect that
+// does not implement DialectFeatures
+type customDialect struct {
+	Dialect
+}
+
+func (customDialect) Name() string {
+	return "custom"
+}
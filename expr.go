@@ -0,0 +1,409 @@
+package jsq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-xorm/builder"
+)
+
+// exprType is the type of the value of an expression
+type exprType string
+
+// Expression types. Fields without a type and values
+// extracted from json have any type.
+const (
+	exprAny    exprType = "any"
+	exprNull   exprType = "null"
+	exprBool   exprType = "boolean"
+	exprNumber exprType = "number"
+	exprString exprType = "string"
+	exprDate   exprType = "date"
+)
+
+var (
+	// exprComparisons maps the comparison operators
+	// of expressions to their SQL symbols
+	exprComparisons = map[string]string{
+		"$eq":  "=",
+		"$ne":  "<>",
+		"$gt":  ">",
+		"$gte": ">=",
+		"$lt":  "<",
+		"$lte": "<=",
+	}
+
+	// exprArithmetic maps the variadic arithmetic
+	// operators of expressions to their SQL symbols
+	exprArithmetic = map[string]string{
+		"$add":      "+",
+		"$multiply": "*",
+	}
+)
+
+// expr is a compiled expression
+type expr struct {
+	sql  string
+	args []interface{}
+	typ  exprType
+
+	// field is the name of the field the expression references
+	field string
+
+	// literal holds the value of a literal expression
+	literal interface{}
+
+	// jsonText marks text extracted from json
+	jsonText bool
+
+	// refs is the number of field references in the expression
+	refs int
+}
+
+// exprTypeOf returns the expression type of a field type. It
+// returns false if the values of the field are not scalars.
+func exprTypeOf(t FieldType) (exprType, bool) {
+	switch t {
+	case TypeAny:
+		return exprAny, true
+	case TypeInt, TypeFloat, TypeDecimal:
+		return exprNumber, true
+	case TypeString, TypeEnum, TypeUUID:
+		return exprString, true
+	case TypeTimestamp, TypeDate:
+		return exprDate, true
+	case TypeBool:
+		return exprBool, true
+	}
+	return "", false
+}
+
+// exprCond returns the condition of a $expr operator. The
+// expression must be a boolean that references a field.
+func (q *JSQ) exprCond(negate bool, v interface{}) (builder.Cond, error) {
+	e, err := q.compileExpr(v)
+	if err != nil {
+		return nil, fmt.Errorf("$expr: %s", err)
+	}
+	if e.refs == 0 {
+		return nil, fmt.Errorf("$expr: expects an expression that references a field")
+	}
+	sql, err := q.predicate(e)
+	if err != nil {
+		return nil, fmt.Errorf("$expr: %s", err)
+	}
+	return fieldExpr(negate, sql, e.args...), nil
+}
+
+// predicate returns the SQL of a boolean expression
+// that can be used as a condition
+func (q *JSQ) predicate(e expr) (string, error) {
+	switch {
+	case e.typ != exprBool:
+		return "", fmt.Errorf("expects a boolean expression, e.g. {\"$gt\": [\"$a\", \"$b\"]}")
+	case e.literal != nil:
+		if e.literal.(bool) {
+			return "1 = 1", nil
+		}
+		return "1 = 0", nil
+	case e.field != "":
+		return fmt.Sprintf("%s = %s", e.sql, q.dialect.Bool(true)), nil
+	}
+	return e.sql, nil
+}
+
+// compileExpr compiles an aggregation expression. Strings prefixed
+// with $ reference fields, objects apply an operator and other values
+// are literals. Number and boolean literals are written inline.
+func (q *JSQ) compileExpr(v interface{}) (expr, error) {
+	switch v := v.(type) {
+	case nil:
+		return expr{sql: "NULL", typ: exprNull}, nil
+
+	case bool:
+		return expr{sql: q.dialect.Bool(v), typ: exprBool, literal: v}, nil
+
+	case float64:
+		sql := strconv.FormatFloat(v, 'f', -1, 64)
+		if v < 0 {
+			sql = "(" + sql + ")"
+		}
+		return expr{sql: sql, typ: exprNumber, literal: v}, nil
+
+	case string:
+		if !strings.HasPrefix(v, "$") {
+			return expr{sql: "?", args: []interface{}{v}, typ: exprString, literal: v}, nil
+		}
+		return q.fieldRef(v[1:])
+
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return expr{}, fmt.Errorf("expects an object with a single operator")
+		}
+		for op, arg := range v {
+			return q.compileOperator(op, arg)
+		}
+	}
+	return expr{}, fmt.Errorf("expects a field reference, a literal or an operator object")
+}
+
+// fieldRef compiles a reference to a field
+func (q *JSQ) fieldRef(field string) (expr, error) {
+	if !q.isValidField(field) {
+		if err := q.jsonPathError(field); err != nil {
+			return expr{}, err
+		}
+		return expr{}, fmt.Errorf("unknown field: %s", field)
+	}
+	f, _ := q.getField(field)
+	typ, ok := exprTypeOf(f.Type)
+	if !ok {
+		return expr{}, fmt.Errorf("field '%s': %s fields are not supported", field, f.Type)
+	}
+	return expr{sql: q.column(field), typ: typ, field: field, jsonText: f.jsonText, refs: 1}, nil
+}
+
+// compileOperator compiles the operands of an operator. Operands are
+// passed in an array, but the operand of a unary operator may be
+// passed on its own.
+func (q *JSQ) compileOperator(op string, arg interface{}) (expr, error) {
+	args, ok := arg.([]interface{})
+	if !ok {
+		args = []interface{}{arg}
+	}
+	var operands []expr
+	for _, a := range args {
+		e, err := q.compileExpr(a)
+		if err != nil {
+			return expr{}, err
+		}
+		operands = append(operands, e)
+	}
+
+	arity := func(n int) error {
+		if len(operands) != n {
+			return fmt.Errorf("'%s' operator expects %d operands", op, n)
+		}
+		return nil
+	}
+
+	if symbol, ok := exprComparisons[op]; ok {
+		if err := arity(2); err != nil {
+			return expr{}, err
+		}
+		return q.compareExpr(op, symbol, operands[0], operands[1])
+	}
+
+	switch op {
+	case "$and", "$or":
+		if len(operands) == 0 {
+			return expr{}, fmt.Errorf("'%s' operator expects at least one operand", op)
+		}
+		var preds []string
+		for _, e := range operands {
+			pred, err := q.predicate(e)
+			if err != nil {
+				return expr{}, fmt.Errorf("'%s' operator %s", op, err)
+			}
+			preds = append(preds, pred)
+		}
+		return joinExprs(operands, "("+strings.Join(preds, " "+strings.ToUpper(op[1:])+" ")+")", exprBool), nil
+
+	case "$not":
+		if err := arity(1); err != nil {
+			return expr{}, err
+		}
+		pred, err := q.predicate(operands[0])
+		if err != nil {
+			return expr{}, fmt.Errorf("'$not' operator %s", err)
+		}
+		return joinExprs(operands, "NOT ("+pred+")", exprBool), nil
+
+	case "$add", "$multiply":
+		if len(operands) == 0 {
+			return expr{}, fmt.Errorf("'%s' operator expects at least one operand", op)
+		}
+		sqls, err := q.numberOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		return joinExprs(operands, "("+strings.Join(sqls, " "+exprArithmetic[op]+" ")+")", exprNumber), nil
+
+	case "$subtract":
+		if err := arity(2); err != nil {
+			return expr{}, err
+		}
+		a, b := operands[0], operands[1]
+		if a.typ == exprDate && b.typ == exprDate {
+			sql, ok := dateDiff(q.dialect, a.sql, b.sql)
+			if !ok {
+				return expr{}, fmt.Errorf("'$subtract' operator on dates is not supported by the %s dialect", q.dialect.Name())
+			}
+			return joinExprs(operands, sql, exprNumber), nil
+		}
+		sqls, err := q.numberOperands(op, operands)
+		if err != nil {
+			return expr{}, fmt.Errorf("'$subtract' operator expects two numbers or two dates")
+		}
+		return joinExprs(operands, fmt.Sprintf("(%s - %s)", sqls[0], sqls[1]), exprNumber), nil
+
+	case "$divide":
+		if err := arity(2); err != nil {
+			return expr{}, err
+		}
+		sqls, err := q.numberOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		if operands[0].jsonText {
+			sqls[0] = operands[0].sql
+		}
+		// cast the dividend, so that integers are not truncated
+		dividend, ok := castNumeric(q.dialect, sqls[0])
+		if !ok {
+			return expr{}, fmt.Errorf("'$divide' operator is not supported by the %s dialect", q.dialect.Name())
+		}
+		return joinExprs(operands, fmt.Sprintf("(%s / %s)", dividend, sqls[1]), exprNumber), nil
+
+	case "$mod":
+		if err := arity(2); err != nil {
+			return expr{}, err
+		}
+		sqls, err := q.numberOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		sql, ok := modSQL(q.dialect, sqls[0], sqls[1])
+		if !ok {
+			return expr{}, fmt.Errorf("'$mod' operator is not supported by the %s dialect", q.dialect.Name())
+		}
+		return joinExprs(operands, sql, exprNumber), nil
+
+	case "$abs":
+		if err := arity(1); err != nil {
+			return expr{}, err
+		}
+		sqls, err := q.numberOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		return joinExprs(operands, "ABS("+sqls[0]+")", exprNumber), nil
+
+	case "$concat":
+		if len(operands) == 0 {
+			return expr{}, fmt.Errorf("'$concat' operator expects at least one operand")
+		}
+		sqls, err := q.stringOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		return joinExprs(operands, q.dialect.Concat(sqls...), exprString), nil
+
+	case "$toLower":
+		if err := arity(1); err != nil {
+			return expr{}, err
+		}
+		sqls, err := q.stringOperands(op, operands)
+		if err != nil {
+			return expr{}, err
+		}
+		return joinExprs(operands, "LOWER("+sqls[0]+")", exprString), nil
+	}
+
+	return expr{}, fmt.Errorf("unknown operator: %s", op)
+}
+
+// joinExprs returns an expression built from the SQL of operands,
+// with the arguments of the operands in order
+func joinExprs(operands []expr, sql string, typ exprType) expr {
+	e := expr{sql: sql, typ: typ}
+	for _, o := range operands {
+		e.args = append(e.args, o.args...)
+		e.refs += o.refs
+	}
+	return e
+}
+
+// numberOperands returns the SQL of operands that must be numbers.
+// Text extracted from json is cast to a number.
+func (q *JSQ) numberOperands(op string, operands []expr) ([]string, error) {
+	sqls := make([]string, len(operands))
+	for i, e := range operands {
+		if e.typ != exprNumber && e.typ != exprAny {
+			return nil, fmt.Errorf("'%s' operator expects number operands", op)
+		}
+		sqls[i] = e.sql
+		if e.jsonText {
+			sqls[i], _ = castNumeric(q.dialect, e.sql)
+		}
+	}
+	return sqls, nil
+}
+
+// stringOperands returns the SQL of operands that must be strings
+func (q *JSQ) stringOperands(op string, operands []expr) ([]string, error) {
+	sqls := make([]string, len(operands))
+	for i, e := range operands {
+		if e.typ != exprString && e.typ != exprAny {
+			return nil, fmt.Errorf("'%s' operator expects string operands", op)
+		}
+		sqls[i] = e.sql
+	}
+	return sqls, nil
+}
+
+// compareExpr compiles a comparison. A literal compared with a field
+// is validated and coerced to the type of the field, and null is only
+// supported by $eq and $ne.
+func (q *JSQ) compareExpr(op, symbol string, a, b expr) (expr, error) {
+	var err error
+	if a, err = q.coerceLiteral(op, a, b); err != nil {
+		return expr{}, err
+	}
+	if b, err = q.coerceLiteral(op, b, a); err != nil {
+		return expr{}, err
+	}
+	operands := []expr{a, b}
+
+	if a.typ == exprNull || b.typ == exprNull {
+		other := a
+		if a.typ == exprNull {
+			other = b
+		}
+		switch op {
+		case "$eq":
+			return joinExprs(operands, other.sql+" IS NULL", exprBool), nil
+		case "$ne":
+			return joinExprs(operands, other.sql+" IS NOT NULL", exprBool), nil
+		}
+		return expr{}, fmt.Errorf("'%s' operator does not support null", op)
+	}
+
+	if a.typ != b.typ && a.typ != exprAny && b.typ != exprAny {
+		return expr{}, fmt.Errorf("'%s' operator cannot compare %s with %s", op, a.typ, b.typ)
+	}
+	if a.jsonText && b.typ == exprNumber {
+		a.sql, _ = castNumeric(q.dialect, a.sql)
+	}
+	if b.jsonText && a.typ == exprNumber {
+		b.sql, _ = castNumeric(q.dialect, b.sql)
+	}
+	return joinExprs(operands, fmt.Sprintf("%s %s %s", a.sql, symbol, b.sql), exprBool), nil
+}
+
+// coerceLiteral validates and coerces a literal compared
+// with a field against the type of the field
+func (q *JSQ) coerceLiteral(op string, e, other expr) (expr, error) {
+	if e.literal == nil || other.field == "" || other.typ == exprAny {
+		return e, nil
+	}
+	value, err := q.value(other.field, op, e.literal)
+	if err != nil {
+		return expr{}, err
+	}
+	if b, ok := value.(bool); ok {
+		return expr{sql: q.dialect.Bool(b), typ: other.typ, literal: b}, nil
+	}
+	return expr{sql: "?", args: []interface{}{value}, typ: other.typ}, nil
+}
@@ -0,0 +1,134 @@
+package jsq
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpr(t *testing.T) {
+	Convey("Expr", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"spent":  {Type: TypeDecimal},
+			"budget": {Type: TypeDecimal},
+			"qty":    {Type: TypeInt},
+			"start":  {Column: "start_date", Type: TypeDate},
+			"end":    {Column: "end_date", Type: TypeDate},
+			"first":  {Type: TypeString},
+			"last":   {Type: TypeString},
+			"active": {Type: TypeBool},
+			"meta":   {Type: TypeJSON},
+			"tags":   {Type: TypeArray, Items: TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		toSQL := func(query string) (string, []interface{}, error) {
+			if err := jsq.Parse(query); err != nil {
+				return "", nil, err
+			}
+			return jsq.ToSQL()
+		}
+
+		Convey("Should compare fields", func() {
+			sql, args, err := toSQL(`{"$expr": {"$gt": ["$spent", "$budget"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"spent" > "budget"`)
+			So(args, ShouldBeEmpty)
+		})
+
+		Convey("Should compile arithmetic", func() {
+			sql, args, err := toSQL(`{"$expr": {"$lte": [{"$multiply": ["$budget", 1.1]}, {"$add": ["$spent", {"$mod": ["$qty", 3]}, -2]}]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("budget" * 1.1) <= ("spent" + MOD("qty", 3) + (-2))`)
+			So(args, ShouldBeEmpty)
+
+			sql, _, err = toSQL(`{"$expr": {"$gt": [{"$divide": [{"$abs": "$qty"}, 4]}, 1]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(CAST(ABS("qty") AS NUMERIC) / 4) > 1`)
+		})
+
+		Convey("Should subtract dates in milliseconds", func() {
+			sql, _, err := toSQL(`{"$expr": {"$gt": [{"$subtract": ["$end", "$start"]}, 604800000]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `((EXTRACT(EPOCH FROM "end_date") - EXTRACT(EPOCH FROM "start_date")) * 1000) > 604800000`)
+
+			sql, _, err = jsq.ToSQLFor(SQLServer)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `DATEDIFF_BIG(millisecond, [start_date], [end_date]) > 604800000`)
+
+			_, _, err = jsq.ToSQLFor(Generic)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: '$subtract' operator on dates is not supported by the generic dialect")
+		})
+
+		Convey("Should compile string operators", func() {
+			sql, args, err := toSQL(`{"$expr": {"$eq": [{"$toLower": {"$concat": ["$first", " ", "$last"]}}, "ben ada"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `LOWER(("first" || $1 || "last")) = $2`)
+			So(args, ShouldResemble, []interface{}{" ", "ben ada"})
+		})
+
+		Convey("Should coerce literals to the type of the compared field", func() {
+			sql, args, err := toSQL(`{"$expr": {"$and": ["$active", {"$lt": ["$start", "2020-01-02"]}, {"$ne": ["$last", null]}]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("active" = TRUE AND "start_date" < $1 AND "last" IS NOT NULL)`)
+			So(args, ShouldResemble, []interface{}{time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)})
+
+			_, _, err = toSQL(`{"$expr": {"$gt": ["$qty", "many"]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: field 'qty': '$gt' operator expects int value")
+		})
+
+		Convey("Should cast json paths compared with numbers", func() {
+			sql, _, err := toSQL(`{"$expr": {"$gt": ["$meta.size", "$qty"]}}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `CAST(("meta"->>'size') AS NUMERIC) > "qty"`)
+		})
+
+		Convey("Should negate expressions", func() {
+			sql, _, err := toSQL(`{"$nor": [{"$expr": {"$gt": ["$spent", "$budget"]}}]}`)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `NOT ("spent" > "budget")`)
+		})
+
+		Convey("Should return error if types do not match", func() {
+			_, _, err := toSQL(`{"$expr": {"$gt": ["$spent", "$first"]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: '$gt' operator cannot compare number with string")
+
+			_, _, err = toSQL(`{"$expr": {"$gt": [{"$add": ["$first", 1]}, 2]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: '$add' operator expects number operands")
+		})
+
+		Convey("Should return error if a field is unknown or not a scalar", func() {
+			_, _, err := toSQL(`{"$expr": {"$gt": ["$password", 1]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: unknown field: password")
+
+			_, _, err = toSQL(`{"$expr": {"$eq": ["$tags", "a"]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: field 'tags': array fields are not supported")
+		})
+
+		Convey("Should return error if the expression is not a boolean", func() {
+			_, _, err := toSQL(`{"$expr": {"$add": ["$qty", 1]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `$expr: expects a boolean expression, e.g. {"$gt": ["$a", "$b"]}`)
+		})
+
+		Convey("Should return error if the expression does not reference a field", func() {
+			_, _, err := toSQL(`{"$expr": {"$eq": [1, 1]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: expects an expression that references a field")
+		})
+
+		Convey("Should return error if an operator is unknown", func() {
+			_, _, err := toSQL(`{"$expr": {"$pow": ["$qty", 2]}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "$expr: unknown operator: $pow")
+		})
+	})
+}
@@ -0,0 +1,173 @@
+package jsq
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ellcrys/util"
+)
+
+// Field describes how a public field name maps to a column
+type Field struct {
+
+	// Column is the name of the column. If empty, the
+	// public field name is used as the column name.
+	Column string
+
+	// Table optionally qualifies the column with a
+	// table name or alias (e.g. p.age)
+	Table string
+
+	// Description describes the field to API clients
+	Description string
+
+	// Type is the type of the field's values. Values are
+	// validated and coerced to the type.
+	Type FieldType
+
+	// Items is the type of the elements of an array field
+	Items FieldType
+
+	// Values holds the allowed values of an enum field,
+	// or of the elements of an array of enums
+	Values []string
+
+	// CaseInsensitive makes string comparisons on the field
+	// ignore case unless a query sets $options
+	CaseInsensitive bool
+
+	// Ops restricts the compare operators that can be
+	// used on the field. If empty, all operators are allowed.
+	Ops []string
+
+	// expr replaces the column with an SQL expression, such
+	// as an aggregate that a HAVING clause filters on
+	expr string
+
+	// path holds the keys of a path into a json column
+	path []string
+
+	// jsonText marks values extracted from json as text,
+	// which are cast to be compared with numbers
+	jsonText bool
+}
+
+// FieldMap maps public field names to their definitions
+type FieldMap map[string]Field
+
+// fieldsFromWhitelist creates a field map where each
+// field name is also the column name
+func fieldsFromWhitelist(whitelist []string) FieldMap {
+	fields := FieldMap{}
+	for _, name := range whitelist {
+		fields[name] = Field{}
+	}
+	return fields
+}
+
+// SetFields replaces the whitelisted fields with a field map.
+// It returns error if a column or table is not a valid identifier.
+func (q *JSQ) SetFields(fields FieldMap) error {
+	for name, f := range fields {
+		if _, err := resolveField(name, f); err != nil {
+			return err
+		}
+	}
+	q.fields = fields
+	return nil
+}
+
+// Fields returns a copy of the whitelisted fields
+func (q *JSQ) Fields() FieldMap {
+	return copyFields(q.fields)
+}
+
+// copyFields returns a copy of a field map that
+// does not share the slices of its fields
+func copyFields(fields FieldMap) FieldMap {
+	copied := make(FieldMap, len(fields))
+	for name, f := range fields {
+		f.Values = append([]string(nil), f.Values...)
+		f.Ops = append([]string(nil), f.Ops...)
+		f.path = append([]string(nil), f.path...)
+		copied[name] = f
+	}
+	return copied
+}
+
+// resolveField sets the default column of a field
+// and validates its identifiers
+func resolveField(name string, f Field) (Field, error) {
+	if f.Column == "" {
+		f.Column = name
+	}
+	if !isValidIdentifier(f.Column) {
+		return Field{}, fmt.Errorf("field '%s': invalid column name", name)
+	}
+	if f.Table != "" && !isValidIdentifier(f.Table) {
+		return Field{}, fmt.Errorf("field '%s': invalid table name", name)
+	}
+	if !isValidFieldType(f.Type) {
+		return Field{}, fmt.Errorf("field '%s': unknown type: %s", name, f.Type)
+	}
+	if f.Items != TypeAny && !isArrayType(f.Type) {
+		return Field{}, fmt.Errorf("field '%s': items type requires an array type", name)
+	}
+	if !isValidFieldType(f.Items) || isArrayType(f.Items) || f.Items == TypeJSON {
+		return Field{}, fmt.Errorf("field '%s': unknown items type: %s", name, f.Items)
+	}
+	if (f.Type == TypeEnum || f.Items == TypeEnum) && len(f.Values) == 0 {
+		return Field{}, fmt.Errorf("field '%s': enum type requires values", name)
+	}
+	for _, op := range f.Ops {
+		if !util.InStringSlice(compareOperators, op) {
+			return Field{}, fmt.Errorf("field '%s': unknown operator: %s", name, op)
+		}
+	}
+	return f, nil
+}
+
+// getField returns the resolved definition of a public field name.
+// Fields that are not whitelisted are returned with the name as the
+// column if any field is allowed.
+func (q *JSQ) getField(name string) (Field, bool) {
+	f, ok := q.fields[name]
+	if !ok && strings.Contains(name, ".") {
+		return q.jsonPathField(name)
+	}
+	if !ok && !q.allowAnyField {
+		return Field{}, false
+	}
+	f, err := resolveField(name, f)
+	if err != nil {
+		return Field{}, false
+	}
+	return f, true
+}
+
+// column returns the quoted, and optionally table
+// qualified, column of a field
+func (q *JSQ) column(field string) string {
+	f, ok := q.getField(field)
+	if !ok {
+		f.Column = field
+	}
+	if f.expr != "" {
+		return f.expr
+	}
+	column := q.dialect.QuoteIdent(f.Column)
+	if f.Table != "" && !q.unqualified {
+		column = q.dialect.QuoteIdent(f.Table) + "." + column
+	}
+	if len(f.path) > 0 {
+		column, _ = jsonPath(q.dialect, column, f.path)
+	}
+	return column
+}
+
+// isAllowedOperator checks whether a compare
+// operator can be used on a field
+func (q *JSQ) isAllowedOperator(field, op string) bool {
+	f, _ := q.getField(field)
+	return len(f.Ops) == 0 || util.InStringSlice(f.Ops, op)
+}
@@ -0,0 +1,60 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFields(t *testing.T) {
+	Convey("Fields", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"age":  {Column: "person_age", Table: "p", Description: "age in years"},
+			"name": {},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".SetFields", func() {
+			Convey("Should return error if a column is not a valid identifier", func() {
+				err := jsq.SetFields(FieldMap{"age": {Column: "age;"}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': invalid column name")
+			})
+
+			Convey("Should return error if a table is not a valid identifier", func() {
+				err := jsq.SetFields(FieldMap{"age": {Table: "p.q"}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': invalid table name")
+			})
+		})
+
+		Convey(".Fields", func() {
+			So(jsq.Fields()["age"].Description, ShouldEqual, "age in years")
+		})
+
+		Convey(".column", func() {
+			So(jsq.column("age"), ShouldEqual, `"p"."person_age"`)
+			So(jsq.column("name"), ShouldEqual, `"name"`)
+		})
+
+		Convey("Should emit mapped columns", func() {
+			err := jsq.Parse(`{"age": { "$gt": 21 }}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `"p"."person_age" > $1`)
+			So(args, ShouldResemble, []interface{}{float64(21)})
+		})
+
+		Convey("Should refer to the public field name in errors", func() {
+			err := jsq.Parse(`{"age": { "$in": 21 }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'age': '$in' operator supports only array type")
+
+			err = jsq.Parse(`{"person_age": 21}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: person_age")
+		})
+	})
+}
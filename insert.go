@@ -0,0 +1,407 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// documents holds parsed documents to insert
+type documents struct {
+
+	// fields holds the fields of every document in order
+	fields []string
+
+	// rows holds the coerced values of each document
+	rows [][]interface{}
+}
+
+// arrayValue holds the coerced elements of
+// a value to insert into an array field
+type arrayValue []interface{}
+
+// upsertRequest holds a parsed upsert request
+type upsertRequest struct {
+
+	// keys holds the fields the filter matches by equality.
+	// Their columns must form a unique key.
+	keys []string
+
+	// values holds the coerced value of each key
+	values []interface{}
+}
+
+// ParseInsert parses a document or an array of documents to
+// insert. Fields are validated against the whitelist and values
+// against the field types. Every document must have the same fields,
+// and a field can only appear once in a document.
+func (q *JSQ) ParseInsert(jsonDocs string) error {
+	q.documents = documents{}
+	if err := q.checkInputLimit([]byte(jsonDocs)); err != nil {
+		return err
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal([]byte(jsonDocs), &raws); err != nil {
+		raws = []json.RawMessage{json.RawMessage(jsonDocs)}
+	}
+	if len(raws) == 0 {
+		return fmt.Errorf("insert: expects at least one document")
+	}
+
+	var docs documents
+	for i, raw := range raws {
+		fields, err := orderedKeys(raw)
+		if err != nil {
+			return fmt.Errorf("malformed json")
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return fmt.Errorf("malformed json")
+		}
+		if len(fields) != len(doc) {
+			return fmt.Errorf("insert: document %d has duplicate field: %s", i, duplicateKey(fields))
+		}
+		if len(doc) == 0 {
+			return fmt.Errorf("insert: document %d is empty", i)
+		}
+
+		if i == 0 {
+			docs.fields = fields
+		} else if len(doc) != len(docs.fields) {
+			return fmt.Errorf("insert: document %d does not have the same fields as the first document", i)
+		}
+
+		row := make([]interface{}, len(docs.fields))
+		for j, field := range docs.fields {
+			v, ok := doc[field]
+			if !ok {
+				return fmt.Errorf("insert: document %d does not have the same fields as the first document", i)
+			}
+			value, err := q.insertValue(field, v)
+			if err != nil {
+				return err
+			}
+			row[j] = value
+		}
+		docs.rows = append(docs.rows, row)
+	}
+
+	q.documents = docs
+	return nil
+}
+
+// duplicateKey returns the first key that appears twice
+func duplicateKey(keys []string) string {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			return key
+		}
+		seen[key] = true
+	}
+	return ""
+}
+
+// insertValue validates and coerces a value to insert into a field.
+// Only json fields accept objects, and only json and array fields
+// accept arrays.
+func (q *JSQ) insertValue(field string, v interface{}) (interface{}, error) {
+	if !q.isValidField(field) {
+		return nil, fmt.Errorf("insert: unknown field: %s", field)
+	}
+	if q.isJSONPath(field) {
+		return nil, fmt.Errorf("insert: field '%s': json paths cannot be inserted", field)
+	}
+	f, _ := q.getField(field)
+	if v == nil {
+		return nil, nil
+	}
+	if err := q.checkStringLimit(field, v); err != nil {
+		return nil, err
+	}
+	if f.Type == TypeArray {
+		return q.insertArray(field, f, v)
+	}
+	if f.Type != TypeJSON && f.Type != TypeJSONArray && !q.isScalar(v) {
+		return nil, fmt.Errorf("field '%s': expects string, number, boolean or null type", field)
+	}
+	coerced, ok := coerce(f, v)
+	if !ok {
+		return nil, fmt.Errorf("field '%s': expects %s value", field, describeType(f))
+	}
+	return coerced, nil
+}
+
+// insertArray validates and coerces the elements of
+// a value to insert into the array field f
+func (q *JSQ) insertArray(field string, f Field, v interface{}) (interface{}, error) {
+	if !supportsArrays(q.dialect) {
+		return nil, fmt.Errorf("field '%s': array fields are not supported by the %s dialect", field, q.dialect.Name())
+	}
+	elems, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("field '%s': expects array type", field)
+	}
+	items := elementField(f)
+	array := make(arrayValue, len(elems))
+	for i, e := range elems {
+		if e == nil || !q.isScalar(e) {
+			return nil, fmt.Errorf("field '%s': array elements must be strings, numbers or booleans", field)
+		}
+		coerced, ok := coerce(items, e)
+		if !ok {
+			return nil, fmt.Errorf("field '%s': array elements expect %s value", field, describeType(items))
+		}
+		array[i] = coerced
+	}
+	return array, nil
+}
+
+// valueSQL returns the SQL of a value to insert. Null and
+// booleans are written as literals, arrays with the ARRAY
+// constructor and other values are bound.
+func (q *JSQ) valueSQL(v interface{}) (string, []interface{}) {
+	switch b := v.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		return q.dialect.Bool(b), nil
+	case arrayValue:
+		if len(b) == 0 {
+			return "'{}'", nil
+		}
+		placeHolders := strings.TrimRight(strings.Repeat("?,", len(b)), ",")
+		return "ARRAY[" + placeHolders + "]", b
+	}
+	return "?", []interface{}{v}
+}
+
+// Insert returns an INSERT statement that inserts
+// the documents parsed by ParseInsert into table
+func (q *JSQ) Insert(table string) (string, []interface{}, error) {
+	name, err := q.quoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(q.documents.rows) == 0 {
+		return "", nil, fmt.Errorf("insert: no document parsed")
+	}
+
+	columns := make([]string, len(q.documents.fields))
+	for i, field := range q.documents.fields {
+		columns[i] = q.updateColumn(field)
+	}
+
+	var args []interface{}
+	rows := make([]string, len(q.documents.rows))
+	for i, row := range q.documents.rows {
+		values := make([]string, len(row))
+		for j, v := range row {
+			var valueArgs []interface{}
+			values[j], valueArgs = q.valueSQL(v)
+			args = append(args, valueArgs...)
+		}
+		rows[i] = "(" + strings.Join(values, ", ") + ")"
+	}
+
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", name, strings.Join(columns, ", "), strings.Join(rows, ", "))
+	return rebind(q.dialect, stmt), args, nil
+}
+
+// ParseUpdateRequest parses an update request in the form:
+//
+//	{"filter": {"email": "ben@x.io"}, "update": {"$set": {"name": "ben"}}, "upsert": true}
+//
+// The filter is parsed as with Parse and the update as with
+// ParseUpdate. If upsert is true, Update returns an upsert statement
+// that inserts a row when no row matches the filter. An upsert filter
+// may only match fields of the table by equality, and the columns of
+// those fields must form a unique key of the table.
+func (q *JSQ) ParseUpdateRequest(jsonRequest string) error {
+	q.upsert = nil
+	if err := q.checkInputLimit([]byte(jsonRequest)); err != nil {
+		return err
+	}
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonRequest), &req); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	for key := range req {
+		if key != "filter" && key != "update" && key != "upsert" {
+			return fmt.Errorf("unknown key: %s", key)
+		}
+	}
+
+	filter := req["filter"]
+	if filter == nil {
+		filter = json.RawMessage("{}")
+	}
+	if err := q.Parse(string(filter)); err != nil {
+		return err
+	}
+	if req["update"] == nil {
+		return fmt.Errorf("update: expects an update document")
+	}
+	if err := q.ParseUpdate(string(req["update"])); err != nil {
+		return err
+	}
+
+	var upsert bool
+	if raw, ok := req["upsert"]; ok && json.Unmarshal(raw, &upsert) != nil {
+		return fmt.Errorf("upsert: expects a boolean")
+	}
+	if !upsert {
+		return nil
+	}
+
+	keys, err := orderedKeys(filter)
+	if err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	var filterDoc map[string]interface{}
+	if err := json.Unmarshal(filter, &filterDoc); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("upsert: filter must match at least one field")
+	}
+
+	var request upsertRequest
+	for _, field := range keys {
+		v := filterDoc[field]
+		if m, ok := v.(map[string]interface{}); ok {
+			v = m["$eq"]
+			if len(m) != 1 || v == nil {
+				return fmt.Errorf("upsert: filter field '%s' must be matched by equality", field)
+			}
+		}
+		if strings.HasPrefix(field, "$") || v == nil {
+			return fmt.Errorf("upsert: filter field '%s' must be matched by equality", field)
+		}
+		if q.isJSONPath(field) {
+			return fmt.Errorf("upsert: filter field '%s' cannot be a json path", field)
+		}
+		if _, ok := q.relationOf(field); ok {
+			return fmt.Errorf("upsert: filter field '%s' cannot be a field of a relation", field)
+		}
+		if q.isArrayField(field) {
+			return fmt.Errorf("upsert: filter field '%s' must be matched by equality", field)
+		}
+		value, err := q.value(field, "$eq", v)
+		if err != nil {
+			return err
+		}
+		for _, a := range q.assignments {
+			if a.field == field {
+				return fmt.Errorf("upsert: field '%s' cannot be in both the filter and the update", field)
+			}
+			if a.op == "$rename" {
+				return fmt.Errorf("upsert: '$rename' operator is not supported")
+			}
+		}
+		request.keys = append(request.keys, field)
+		request.values = append(request.values, value)
+	}
+
+	q.upsert = &request
+	return nil
+}
+
+// Upsert returns a statement that inserts a row built from the filter
+// and update parsed by ParseUpdateRequest, or updates the existing
+// row if one matches the filter. It uses ON CONFLICT on Postgres,
+// CockroachDB and SQLite, ON DUPLICATE KEY UPDATE on MySQL and MERGE
+// on SQL Server.
+func (q *JSQ) Upsert(table string) (string, []interface{}, error) {
+	name, err := q.quoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+	if q.upsert == nil {
+		return "", nil, fmt.Errorf("upsert: no upsert request parsed")
+	}
+	style := upsertStyle(q.dialect)
+	if style == "" {
+		return "", nil, fmt.Errorf("upsert is not supported by the %s dialect", q.dialect.Name())
+	}
+
+	columns, values, args := q.upsertValues()
+	keys := make([]string, len(q.upsert.keys))
+	for i, field := range q.upsert.keys {
+		keys[i] = q.updateColumn(field)
+	}
+
+	var stmt string
+	switch style {
+	case UpsertOnConflict:
+		// columns read by the update must be qualified, as the
+		// row proposed for insertion is also in scope
+		set, setArgs := q.setSQL(q.dialect.QuoteIdent(table[strings.LastIndex(table, ".")+1:]))
+		stmt = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+			name, strings.Join(columns, ", "), strings.Join(values, ", "), strings.Join(keys, ", "), set)
+		args = append(args, setArgs...)
+
+	case UpsertOnDuplicateKey:
+		set, setArgs := q.setSQL("")
+		stmt = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+			name, strings.Join(columns, ", "), strings.Join(values, ", "), set)
+		args = append(args, setArgs...)
+
+	case UpsertMerge:
+		target, source := q.dialect.QuoteIdent("target"), q.dialect.QuoteIdent("source")
+		selects := make([]string, len(columns))
+		inserts := make([]string, len(columns))
+		for i, column := range columns {
+			selects[i] = values[i] + " AS " + column
+			inserts[i] = source + "." + column
+		}
+		on := make([]string, len(keys))
+		for i, key := range keys {
+			on[i] = fmt.Sprintf("%s.%s = %s.%s", target, key, source, key)
+		}
+		set, setArgs := q.setSQL(target)
+		stmt = fmt.Sprintf("MERGE INTO %s WITH (HOLDLOCK) AS %s USING (SELECT %s) AS %s ON %s "+
+			"WHEN MATCHED THEN UPDATE SET %s WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+			name, target, strings.Join(selects, ", "), source, strings.Join(on, " AND "),
+			set, strings.Join(columns, ", "), strings.Join(inserts, ", "))
+		args = append(args, setArgs...)
+	}
+
+	return rebind(q.dialect, stmt), args, nil
+}
+
+// upsertValues returns the columns and values of the row an upsert
+// inserts. It holds the filter keys and the value each update
+// operator gives a field that does not exist, as MongoDB does.
+func (q *JSQ) upsertValues() ([]string, []string, []interface{}) {
+	var columns, values []string
+	var args []interface{}
+	add := func(field string, v interface{}) {
+		sql, valueArgs := q.valueSQL(v)
+		columns = append(columns, q.updateColumn(field))
+		values = append(values, sql)
+		args = append(args, valueArgs...)
+	}
+
+	for i, field := range q.upsert.keys {
+		add(field, q.upsert.values[i])
+	}
+	for _, a := range q.assignments {
+		switch a.op {
+		case "$set", "$inc", "$min", "$max":
+			add(a.field, a.value)
+		case "$mul":
+			columns = append(columns, q.updateColumn(a.field))
+			values = append(values, "0")
+		case "$currentDate":
+			columns = append(columns, q.updateColumn(a.field))
+			if a.value == "date" {
+				values = append(values, "CURRENT_DATE")
+			} else {
+				values = append(values, "CURRENT_TIMESTAMP")
+			}
+		}
+	}
+	return columns, values, args
+}
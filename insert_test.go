@@ -0,0 +1,212 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInsert(t *testing.T) {
+	Convey("Insert", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"email":  {Type: TypeString},
+			"name":   {Type: TypeString},
+			"age":    {Type: TypeInt},
+			"active": {Type: TypeBool},
+			"meta":   {Type: TypeJSON},
+			"seen":   {Column: "last_seen", Type: TypeTimestamp},
+			"visits": {Type: TypeInt},
+			"tags":   {Type: TypeArray, Items: TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".ParseInsert", func() {
+			Convey("Should return error if field is not whitelisted", func() {
+				err := jsq.ParseInsert(`{"password": "x"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "insert: unknown field: password")
+			})
+
+			Convey("Should return error if value does not match the field type", func() {
+				err := jsq.ParseInsert(`{"age": "old"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': expects int value")
+				err = jsq.ParseInsert(`{"name": ["a"]}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': expects string, number, boolean or null type")
+			})
+
+			Convey("Should return error if documents have different fields", func() {
+				err := jsq.ParseInsert(`[{"name": "a", "age": 1}, {"name": "b", "email": "x"}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "insert: document 1 does not have the same fields as the first document")
+			})
+
+			Convey("Should return error if a field is duplicated", func() {
+				err := jsq.ParseInsert(`[{"name": "a"}, {"name": "b", "name": "c"}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "insert: document 1 has duplicate field: name")
+			})
+
+			Convey("Should return error if an array element does not match the items type", func() {
+				err := jsq.ParseInsert(`{"tags": ["a", 1]}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'tags': array elements expect string value")
+
+				err = jsq.ParseInsert(`{"tags": "a"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'tags': expects array type")
+
+				jsq := NewJSQWithDialect(nil, MySQL)
+				So(jsq.SetFields(FieldMap{"tags": {Type: TypeArray, Items: TypeString}}), ShouldBeNil)
+				err = jsq.ParseInsert(`{"tags": ["a"]}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'tags': array fields are not supported by the mysql dialect")
+			})
+
+			Convey("Should return error if there is no document", func() {
+				err := jsq.ParseInsert(`[]`)
+				So(err, ShouldNotBeNil)
+				err = jsq.ParseInsert(`{}`)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".Insert", func() {
+			Convey("Should return error if no document was parsed", func() {
+				_, _, err := jsq.Insert("people")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "insert: no document parsed")
+			})
+
+			Convey("Should insert a document", func() {
+				err := jsq.ParseInsert(`{"name": "ben", "age": 20, "active": true, "meta": {"a": 1}, "email": null}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Insert("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `INSERT INTO "people" ("name", "age", "active", "meta", "email") VALUES ($1, $2, TRUE, $3, NULL)`)
+				So(args, ShouldResemble, []interface{}{"ben", int64(20), `{"a":1}`})
+			})
+
+			Convey("Should insert array fields", func() {
+				err := jsq.ParseInsert(`[{"name": "ben", "tags": ["go", "sql"]}, {"name": "ana", "tags": []}]`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Insert("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `INSERT INTO "people" ("name", "tags") VALUES ($1, ARRAY[$2,$3]), ($4, '{}')`)
+				So(args, ShouldResemble, []interface{}{"ben", "go", "sql", "ana"})
+			})
+
+			Convey("Should insert an array of documents", func() {
+				err := jsq.ParseInsert(`[{"name": "ben", "age": 20}, {"age": 30, "name": "ana"}]`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Insert("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `INSERT INTO "people" ("name", "age") VALUES ($1, $2), ($3, $4)`)
+				So(args, ShouldResemble, []interface{}{"ben", int64(20), "ana", int64(30)})
+			})
+		})
+
+		Convey(".ParseUpdateRequest", func() {
+			Convey("Should return error if the filter does not match by equality", func() {
+				err := jsq.ParseUpdateRequest(`{"filter": {"age": {"$gt": 1}}, "update": {"$set": {"name": "ben"}}, "upsert": true}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert: filter field 'age' must be matched by equality")
+			})
+
+			Convey("Should return error if the filter matches a field of a relation", func() {
+				err := jsq.SetRelations(RelationMap{"company": {
+					Local:   "people.company_id",
+					Foreign: "companies.id",
+					Fields:  FieldMap{"country": {Type: TypeString}},
+				}})
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdateRequest(`{"filter": {"company.country": "NG"}, "update": {"$set": {"name": "ben"}}, "upsert": true}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert: filter field 'company.country' cannot be a field of a relation")
+			})
+
+			Convey("Should return error if the filter is empty", func() {
+				err := jsq.ParseUpdateRequest(`{"update": {"$set": {"name": "ben"}}, "upsert": true}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert: filter must match at least one field")
+			})
+
+			Convey("Should return error if a field is in the filter and the update", func() {
+				err := jsq.ParseUpdateRequest(`{"filter": {"email": "a@b.c"}, "update": {"$set": {"email": "x"}}, "upsert": true}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert: field 'email' cannot be in both the filter and the update")
+			})
+
+			Convey("Should generate a plain update if upsert is not set", func() {
+				err := jsq.ParseUpdateRequest(`{"filter": {"email": "a@b.c"}, "update": {"$set": {"name": "ben"}}}`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "name" = $1 WHERE "email" = $2`)
+			})
+		})
+
+		Convey(".Upsert", func() {
+			request := `{
+				"filter": {"email": {"$eq": "a@b.c"}},
+				"update": {"$set": {"name": "ben"}, "$inc": {"visits": 1}, "$currentDate": {"seen": true}},
+				"upsert": true
+			}`
+
+			Convey("Should use ON CONFLICT on Postgres", func() {
+				err := jsq.ParseUpdateRequest(request)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Update("public.people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `INSERT INTO "public"."people" ("email", "name", "visits", "last_seen") VALUES ($1, $2, $3, CURRENT_TIMESTAMP) `+
+					`ON CONFLICT ("email") DO UPDATE SET "name" = $4, "visits" = COALESCE("people"."visits", 0) + $5, "last_seen" = CURRENT_TIMESTAMP`)
+				So(args, ShouldResemble, []interface{}{"a@b.c", "ben", int64(1), "ben", int64(1)})
+			})
+
+			Convey("Should use ON DUPLICATE KEY UPDATE on MySQL", func() {
+				jsq.dialect = MySQL
+				err := jsq.ParseUpdateRequest(request)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Upsert("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, "INSERT INTO `people` (`email`, `name`, `visits`, `last_seen`) VALUES (?, ?, ?, CURRENT_TIMESTAMP) "+
+					"ON DUPLICATE KEY UPDATE `name` = ?, `visits` = COALESCE(`visits`, 0) + ?, `last_seen` = CURRENT_TIMESTAMP")
+			})
+
+			Convey("Should use MERGE on SQL Server", func() {
+				jsq.dialect = SQLServer
+				err := jsq.ParseUpdateRequest(request)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Upsert("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, "MERGE INTO [people] WITH (HOLDLOCK) AS [target] "+
+					"USING (SELECT @p1 AS [email], @p2 AS [name], @p3 AS [visits], CURRENT_TIMESTAMP AS [last_seen]) AS [source] "+
+					"ON [target].[email] = [source].[email] "+
+					"WHEN MATCHED THEN UPDATE SET [name] = @p4, [visits] = COALESCE([target].[visits], 0) + @p5, [last_seen] = CURRENT_TIMESTAMP "+
+					"WHEN NOT MATCHED THEN INSERT ([email], [name], [visits], [last_seen]) "+
+					"VALUES ([source].[email], [source].[name], [source].[visits], [source].[last_seen]);")
+				So(len(args), ShouldEqual, 5)
+			})
+
+			Convey("Should return error if the dialect has no upsert", func() {
+				jsq.dialect = Oracle
+				err := jsq.ParseUpdateRequest(request)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Upsert("people")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert is not supported by the oracle dialect")
+			})
+
+			Convey("Should return error if the dialect does not implement DialectFeatures", func() {
+				jsq.dialect = customDialect{Postgres}
+				err := jsq.ParseUpdateRequest(request)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Upsert("people")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "upsert is not supported by the custom dialect")
+			})
+		})
+	})
+}
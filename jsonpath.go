@@ -0,0 +1,77 @@
+package jsq
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// jsonIndexPattern describes the array indexes of a json path
+var jsonIndexPattern = regexp.MustCompile(`^[0-9]{1,9}$`)
+
+// isJSONIndex checks whether a key of a json path is an array index
+func isJSONIndex(key string) bool {
+	return jsonIndexPattern.MatchString(key)
+}
+
+// jsonPathField returns the field of a path into a json field, such
+// as meta.color or meta.tags.0. Keys that are numbers index arrays.
+// It returns false if the path does not start with a json field or
+// the dialect does not support json paths.
+func (q *JSQ) jsonPathField(name string) (Field, bool) {
+	keys := strings.Split(name, ".")
+	f, ok := q.fields[keys[0]]
+	if !ok || f.Type != TypeJSON {
+		return Field{}, false
+	}
+	f, err := resolveField(keys[0], f)
+	if err != nil {
+		return Field{}, false
+	}
+	if _, ok := jsonPath(q.dialect, "", keys[1:]); !ok {
+		return Field{}, false
+	}
+	for _, key := range keys[1:] {
+		if !isValidIdentifier(key) && !isJSONIndex(key) {
+			return Field{}, false
+		}
+	}
+	return Field{
+		Column:   f.Column,
+		Table:    f.Table,
+		Ops:      f.Ops,
+		path:     keys[1:],
+		jsonText: true,
+	}, true
+}
+
+// jsonPathError returns an error if a field is a path into a json
+// field and the dialect cannot extract json paths
+func (q *JSQ) jsonPathError(name string) error {
+	keys := strings.Split(name, ".")
+	f, ok := q.fields[keys[0]]
+	if len(keys) == 1 || !ok || f.Type != TypeJSON {
+		return nil
+	}
+	if _, ok := jsonPath(q.dialect, "", keys[1:]); !ok {
+		return fmt.Errorf("field '%s': json paths are not supported by the %s dialect", name, q.dialect.Name())
+	}
+	return nil
+}
+
+// isJSONPath checks whether a field is a path into a json field
+func (q *JSQ) isJSONPath(field string) bool {
+	f, _ := q.getField(field)
+	return len(f.path) > 0
+}
+
+// valueColumn returns the column a value is compared with. As json
+// values are extracted as text, they are cast to numbers to be
+// compared with numbers.
+func (q *JSQ) valueColumn(field string, value interface{}) string {
+	column := q.column(field)
+	if f, _ := q.getField(field); f.jsonText && q.isNumber(value) {
+		column, _ = castNumeric(q.dialect, column)
+	}
+	return column
+}
@@ -0,0 +1,94 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJSONPath(t *testing.T) {
+	Convey("JSONPath", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"meta": {Type: TypeJSON},
+			"name": {Type: TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Should compare the text at a path", func() {
+			err := jsq.Parse(`{"meta.color": "red"}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("meta"->>'color') = $1`)
+			So(args, ShouldResemble, []interface{}{"red"})
+		})
+
+		Convey("Should cast the path to compare it with numbers", func() {
+			err := jsq.Parse(`{"meta.size": {"$gt": 10}}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `CAST(("meta"->>'size') AS NUMERIC) > $1`)
+			So(args, ShouldResemble, []interface{}{10.0})
+
+			err = jsq.Parse(`{"meta.size": {"$in": [1, 2]}}`)
+			So(err, ShouldBeNil)
+			sql, _, err = jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `CAST(("meta"->>'size') AS NUMERIC) IN ($1,$2)`)
+		})
+
+		Convey("Should index arrays", func() {
+			err := jsq.Parse(`{"meta.tags.0": "new", "meta.dims.0.w": {"$exists": true}}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `("meta"->'tags'->>0) = $`)
+			So(sql, ShouldContainSubstring, `("meta"->'dims'->0->>'w') IS NOT NULL`)
+		})
+
+		Convey("Should compare booleans with their json text", func() {
+			err := jsq.Parse(`{"meta.active": true}`)
+			So(err, ShouldBeNil)
+			_, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{"true"})
+		})
+
+		Convey("Should extract paths for other dialects", func() {
+			err := jsq.Parse(`{"meta.dims.0.w": {"$lte": 5}}`)
+			So(err, ShouldBeNil)
+
+			sql, _, err := jsq.ToSQLFor(MySQL)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "CAST(JSON_UNQUOTE(JSON_EXTRACT(`meta`, '$.dims[0].w')) AS DECIMAL(65,30)) <= ?")
+
+			sql, _, err = jsq.ToSQLFor(SQLite)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `CAST(json_extract("meta", '$.dims[0].w') AS REAL) <= ?`)
+
+			sql, _, err = jsq.ToSQLFor(SQLServer)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `CAST(JSON_VALUE([meta], '$.dims[0].w') AS FLOAT) <= @p1`)
+		})
+
+		Convey("Should return error if the path does not start with a json field", func() {
+			err := jsq.Parse(`{"name.first": "ben"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: name.first")
+		})
+
+		Convey("Should return error if a key of the path is invalid", func() {
+			err := jsq.Parse(`{"meta.col'or": "red"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: meta.col'or")
+		})
+
+		Convey("Should return error if a path is updated", func() {
+			err := jsq.ParseUpdate(`{"$set": {"meta.color": "red"}}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "update: field 'meta.color': json paths cannot be updated")
+		})
+	})
+}
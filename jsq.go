@@ -1,13 +1,15 @@
 package jsq
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"reflect"
 
 	"github.com/ellcrys/util"
-	. "github.com/go-xorm/builder"
+	"github.com/go-xorm/builder"
 )
 
 // Query defines an interface for JSQL query implementations
@@ -20,6 +22,9 @@ type Query interface {
 	ToSQL() (string, []interface{}, error)
 }
 
+// DefaultMaxRegexLength is the default maximum length of a $regex pattern
+const DefaultMaxRegexLength = 256
+
 var (
 	// ErrNotFound indicates a missing data
 	ErrNotFound = fmt.Errorf("not found")
@@ -31,29 +36,47 @@ var (
 	}
 
 	compareOperators = []string{
-		"$eq",  // equal
-		"$gt",  // greater than
-		"$gte", // greater than or equal
-		"$lt",  // less than
-		"$lte", // less than or equal
-		"$ne",  // not equal
-		"$in",  // in array
-		"$nin", // not in array
-		"$not", // not (negate)
-		"$sw",  // starts with
-		"$ew",  // end with
-		"$ct",  // contains
+		"$eq",        // equal
+		"$gt",        // greater than
+		"$gte",       // greater than or equal
+		"$lt",        // less than
+		"$lte",       // less than or equal
+		"$ne",        // not equal
+		"$in",        // in array
+		"$nin",       // not in array
+		"$not",       // not (negate)
+		"$sw",        // starts with
+		"$ew",        // end with
+		"$ct",        // contains
+		"$exists",    // is not null (or null)
+		"$like",      // raw LIKE pattern
+		"$ieq",       // case-insensitive equal
+		"$ict",       // case-insensitive contains
+		"$options",   // string operator options
+		"$regex",     // matches regular expression
+		"$all",       // array contains all values
+		"$size",      // array has number of elements
+		"$elemMatch", // array has an element matching operators
 	}
+
+	// caseOptions holds the supported string operator options
+	caseOptions = "i"
+
+	// identifierPattern describes the grammar of a field name
+	// that is safe to use as an SQL identifier
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
 )
 
 // parserCtx hold information about a JSQ to be parsed
 type parserCtx struct {
-	b      *Builder
+	b      *builder.Builder
 	negate bool
 }
 
 // QueryOption provides fields that can be used to
-// alter a query
+// alter a query.
+//
+// Deprecated: use FindOptions with ParseOptions or ParseFind.
 type QueryOption struct {
 	OrderBy string
 	Limit   int
@@ -62,16 +85,90 @@ type QueryOption struct {
 // JSQ defines a structure for constructing a query
 // from json objects.
 type JSQ struct {
-	b *Builder
+	b *builder.Builder
+
+	// node is the AST of the last successfully parsed query
+	node Node
+
+	// dialect controls how SQL is written
+	dialect Dialect
+
+	// fields holds the whitelisted fields
+	fields FieldMap
+
+	// allowAnyField permits fields that are not whitelisted
+	allowAnyField bool
+
+	// allowLikePatterns enables the $like operator
+	allowLikePatterns bool
+
+	// maxRegexLength is the maximum length of a $regex pattern
+	maxRegexLength int
+
+	// canonical parses the keys of queries in sorted order
+	canonical bool
+
+	// bindBools binds booleans as arguments rather than
+	// writing them as literals, as templates require
+	bindBools bool
+
+	// limits caps the complexity of queries
+	limits Limits
+
+	// options holds the parsed find options
+	options FindOptions
+
+	// maxLimit is the maximum limit a query may request
+	maxLimit int
+
+	// cursorKey is the secret used to sign cursors
+	cursorKey []byte
 
-	// fieldWhitelist holds a list of valid field names
-	fieldWhitelist []string
+	// assignments holds the parsed update document
+	assignments []assignment
+
+	// allowUnfilteredWrites permits writes without a filter
+	allowUnfilteredWrites bool
+
+	// deleteLimit is the maximum number of rows to delete
+	deleteLimit int
+
+	// documents holds the parsed documents to insert
+	documents documents
+
+	// upsert holds the parsed upsert request
+	upsert *upsertRequest
+
+	// pipeline holds the stages of the parsed aggregation pipeline
+	pipeline []pipelineStage
+
+	// relations holds the tables related to the queried table
+	relations map[string]relation
+
+	// joinTable replaces the local table of relations in join
+	// conditions, e.g. with the alias of the queried table
+	joinTable string
+
+	// unqualified drops the table of fields from their columns,
+	// as write statements only declare the table they modify
+	unqualified bool
 }
 
 // NewJSQ connects to the database server and returns a new instance
+// that uses the Generic dialect
 func NewJSQ(fieldWhitelist []string) *JSQ {
+	return NewJSQWithDialect(fieldWhitelist, Generic)
+}
+
+// NewJSQWithDialect returns a new instance that generates SQL for dialect
+func NewJSQWithDialect(fieldWhitelist []string, dialect Dialect) *JSQ {
+	if dialect == nil {
+		dialect = Generic
+	}
 	return &JSQ{
-		fieldWhitelist: fieldWhitelist,
+		dialect:        dialect,
+		fields:         fieldsFromWhitelist(fieldWhitelist),
+		maxRegexLength: DefaultMaxRegexLength,
 	}
 }
 
@@ -79,18 +176,52 @@ func NewJSQ(fieldWhitelist []string) *JSQ {
 // containing all the JSQ requirements ready to be executed. It returns error
 // if unable to parse jsonJSQ
 func (q *JSQ) Parse(jsonJSQ string) error {
-	var JSQ map[string]interface{}
-	err := util.FromJSON([]byte(jsonJSQ), &JSQ)
+	node, err := q.parseAST([]byte(jsonJSQ))
 	if err != nil {
-		return fmt.Errorf("malformed json")
+		q.node, q.b, q.upsert = nil, nil, nil
+		return err
 	}
-	return q.parse(JSQ)
+	return q.ParseNode(node)
+}
+
+// AllowAnyField permits fields that are not in the whitelist.
+// Field names must still be valid identifiers. Only enable this
+// if every column of the queried table may be exposed.
+func (q *JSQ) AllowAnyField(allow bool) {
+	q.allowAnyField = allow
+}
+
+// AllowLikePatterns enables the $like operator which passes raw
+// LIKE patterns, including wildcards, to the database. Only enable
+// this for trusted callers.
+func (q *JSQ) AllowLikePatterns(allow bool) {
+	q.allowLikePatterns = allow
+}
+
+// SetCanonical makes queries generate SQL in the sorted order of
+// their keys rather than in the order they are written in, so that
+// queries that only differ in key order generate the same SQL
+func (q *JSQ) SetCanonical(canonical bool) {
+	q.canonical = canonical
+}
+
+// SetMaxRegexLength sets the maximum length of a $regex
+// pattern. A length of zero or less removes the limit.
+func (q *JSQ) SetMaxRegexLength(n int) {
+	q.maxRegexLength = n
+}
+
+// isValidIdentifier checks whether a name is safe to use as an SQL identifier
+func isValidIdentifier(name string) bool {
+	return identifierPattern.MatchString(name)
 }
 
 // isValidField checks whether a JSQ field is an acceptable field.
-// If the valid fields whitelist is empty, all fields are considered valid
+// A field must be whitelisted, unless any field is allowed, and
+// its column must be a valid identifier.
 func (q JSQ) isValidField(f string) bool {
-	return len(q.fieldWhitelist) == 0 || util.InStringSlice(q.fieldWhitelist, f)
+	_, ok := q.getField(f)
+	return ok
 }
 
 // isValidOperator checks whether an operator is include
@@ -101,278 +232,573 @@ func (q *JSQ) isValidOperator(op string, operatorSet []string) bool {
 
 // getBuilder gets builder from a context if set
 // or the builder in the JSQ
-func (q *JSQ) getBuilder(ctx parserCtx) *Builder {
+func (q *JSQ) getBuilder(ctx parserCtx) *builder.Builder {
 	if ctx.b == nil {
 		return q.b
 	}
 	return ctx.b
 }
 
+// contextSQL returns the SQL of a context builder. A builder without
+// SQL, parsed from an empty statement, matches every row, or no row
+// when the statement is negated.
+func contextSQL(b *builder.Builder, negate bool) (string, []interface{}, error) {
+	sql, args, err := builderSQL(b)
+	if err != nil || sql != "" {
+		return sql, args, err
+	}
+	return constantSQL(!negate), nil, nil
+}
+
+// builderSQL returns the SQL of a builder, which
+// is empty if no condition was added to it
+func builderSQL(b *builder.Builder) (string, []interface{}, error) {
+	if reflect.DeepEqual(b, new(builder.Builder)) {
+		return "", nil, nil
+	}
+	return b.ToSQL()
+}
+
+// constantSQL returns a condition that matches
+// every row if match is true, or no row
+func constantSQL(match bool) string {
+	if match {
+		return "1 = 1"
+	}
+	return "1 = 0"
+}
+
 // fieldExpr creates an express that will be prefixed with a NOT clause
 // if negate is true.
-func fieldExpr(negate bool, exp string, args ...interface{}) Cond {
-	not := "NOT"
+func fieldExpr(negate bool, exp string, args ...interface{}) builder.Cond {
 	if !negate {
-		not = ""
+		return builder.Expr(exp, args...)
 	}
-	return Expr(fmt.Sprintf("%s %s", not, exp), args...)
+	return builder.Expr(fmt.Sprintf("NOT (%s)", exp), args...)
 }
 
-// parse parses the JSQ returning a slice of
-// scope functions to pass to the new database scope.
-func (q *JSQ) parse(JSQ map[string]interface{}) error {
+// compareCond returns a comparison of a field against a value using
+// the given symbol. Null values compile to IS [NOT] NULL and booleans
+// to the boolean literals of the dialect, unless booleans are bound.
+func (q *JSQ) compareCond(negate bool, field, symbol string, value interface{}) builder.Cond {
+	column := q.valueColumn(field, value)
+	if b, ok := value.(bool); ok {
+		if f, _ := q.getField(field); f.jsonText {
+			value = jsonBool(q.dialect, b)
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		if symbol == "<>" {
+			return fieldExpr(negate, fmt.Sprintf("%s IS NOT NULL", column))
+		}
+		return fieldExpr(negate, fmt.Sprintf("%s IS NULL", column))
+	case bool:
+		if !q.bindBools {
+			return fieldExpr(negate, fmt.Sprintf("%s %s %s", column, symbol, q.dialect.Bool(v)))
+		}
+	}
+	return fieldExpr(negate, fmt.Sprintf("%s %s ?", column, symbol), value)
+}
 
-	q.b = new(Builder)
-	var _parse func(JSQStatement map[string]interface{}, ctx parserCtx) error
+// equalCond returns an equality condition. String values are
+// compared ignoring case if insensitive is true. Arrays are equal
+// to a value if they contain it.
+func (q *JSQ) equalCond(negate bool, field string, value interface{}, insensitive bool) builder.Cond {
+	if value != nil && q.isArrayField(field) {
+		return q.containsCond(negate, field, value)
+	}
+	if s, ok := value.(string); ok && insensitive {
+		return fieldExpr(negate, q.dialect.EqualFold(q.column(field)), s)
+	}
+	return q.compareCond(negate, field, "=", value)
+}
 
-	// parses the JSQ
-	_parse = func(JSQStatement map[string]interface{}, ctx parserCtx) error {
+// defaultCaseInsensitive checks whether string
+// comparisons on a field ignore case by default
+func (q *JSQ) defaultCaseInsensitive(field string) bool {
+	f, _ := q.getField(field)
+	return f.CaseInsensitive
+}
 
-		for _field, _fieldValue := range JSQStatement {
+// caseInsensitive checks whether the string operators of a field
+// ignore case. The $options operator ("i" to ignore case, "" to
+// respect case) overrides the default of the field.
+func (q *JSQ) caseInsensitive(field string, options *string) (bool, error) {
+	if options == nil {
+		return q.defaultCaseInsensitive(field), nil
+	}
+	for _, o := range *options {
+		if !strings.ContainsRune(caseOptions, o) {
+			return false, fmt.Errorf("field '%s': unknown option: %c", field, o)
+		}
+	}
+	return strings.ContainsRune(*options, 'i'), nil
+}
 
-			field := _field
-			fieldValue := _fieldValue
-			anOperator := field[0] == '$'
+// regexExpr validates a regular expression and returns
+// the match expression of a field and the value to bind
+func (q *JSQ) regexExpr(field, pattern string, insensitive bool) (string, string, error) {
+	if q.maxRegexLength > 0 && len(pattern) > q.maxRegexLength {
+		return "", "", &LimitError{Limit: LimitPatternLength, Max: q.maxRegexLength, Field: field, Op: "$regex"}
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", "", fmt.Errorf("field '%s': '$regex' pattern is invalid", field)
+	}
+	expr, arg, err := q.dialect.Regex(q.column(field), pattern, insensitive)
+	if err != nil {
+		return "", "", fmt.Errorf("field '%s': %s", field, err)
+	}
+	return expr, arg, nil
+}
 
-			// check if field is an operator and also a known top level operator
-			if anOperator && !q.isValidOperator(field, logicalOperators) {
-				return fmt.Errorf("unknown top level operator: %s", field)
+// inCond returns a condition that checks whether a field is (or is
+// not) in a list of values. A null value in the list matches nulls.
+func (q *JSQ) inCond(negate bool, field string, values []interface{}, not bool) builder.Cond {
+	if q.isArrayField(field) {
+		return q.arrayInCond(negate, field, values, not)
+	}
+	column := q.column(field)
+	var hasNull bool
+	var nonNull []interface{}
+	for _, v := range values {
+		if v == nil {
+			hasNull = true
+			continue
+		}
+		nonNull = append(nonNull, v)
+	}
+
+	var exprs []string
+	if len(nonNull) > 0 {
+		column = q.valueColumn(field, nonNull[0])
+		placeHolders := strings.TrimRight(strings.Repeat("?,", len(nonNull)), ",")
+		if not {
+			exprs = append(exprs, fmt.Sprintf(`%s NOT IN (`+placeHolders+`)`, column))
+		} else {
+			exprs = append(exprs, fmt.Sprintf(`%s IN (`+placeHolders+`)`, column))
+		}
+	}
+
+	switch {
+	case not && hasNull:
+		exprs = append(exprs, fmt.Sprintf("%s IS NOT NULL", column))
+		return fieldExpr(negate, strings.Join(exprs, " AND "), nonNull...)
+	case hasNull:
+		exprs = append(exprs, fmt.Sprintf("%s IS NULL", column))
+		return fieldExpr(negate, "("+strings.Join(exprs, " OR ")+")", nonNull...)
+	case len(exprs) == 0 && not:
+		return fieldExpr(negate, "1 = 1")
+	case len(exprs) == 0:
+		return fieldExpr(negate, "1 = 0")
+	}
+	return fieldExpr(negate, exprs[0], nonNull...)
+}
+
+// parse parses a json query object into the builder of the query
+func (q *JSQ) parse(JSQ json.RawMessage) error {
+	node, err := q.ast(JSQ)
+	if err != nil {
+		return err
+	}
+	return q.build(node)
+}
+
+// ParseNode validates an AST, such as one returned by ParseAST, against
+// the fields of the query and prepares it for SQL generation like Parse
+func (q *JSQ) ParseNode(node Node) error {
+	q.node = nil
+	q.upsert = nil
+	if err := q.build(node); err != nil {
+		q.b = nil
+		return err
+	}
+	q.node = node
+	return nil
+}
+
+// build generates the conditions of an AST into a new builder. The
+// nodes of a top level And are added to the builder one by one.
+func (q *JSQ) build(node Node) error {
+	q.b = new(builder.Builder)
+	if err := q.checkLimits(node); err != nil {
+		return err
+	}
+	if and, ok := node.(And); ok && len(and) > 0 {
+		for _, n := range and {
+			if err := q.buildNode(n, parserCtx{}); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
+	if node == nil {
+		return nil
+	}
+	return q.buildNode(node, parserCtx{})
+}
+
+// buildNode adds the condition of a node to the builder of the context.
+// Negation is pushed down to the compare operators of a field, while
+// the conditions of other nodes are negated as a whole.
+func (q *JSQ) buildNode(node Node, ctx parserCtx) error {
+	switch n := node.(type) {
+	case Compare:
+		return q.buildCompare(n, ctx)
+
+	case Not:
+		return q.buildNode(n.Node, parserCtx{b: ctx.b, negate: !ctx.negate})
+
+	case Expr:
+		cond, err := q.exprCond(ctx.negate, n.Value)
+		if err != nil {
+			return err
+		}
+		q.getBuilder(ctx).And(cond)
+
+	case And:
+		ctxBuilder := new(builder.Builder)
+		for _, stmt := range n {
+
+			// parse statement. Set a custom builder for the parsers
+			if err := q.buildNode(stmt, parserCtx{b: ctxBuilder}); err != nil {
+				return err
+			}
+		}
+
+		ctxSQL, args, err := builderSQL(ctxBuilder)
+		if err != nil {
+			return fmt.Errorf("failed to construct sql from context builder")
+		}
+
+		// an empty builder matches every row (or none, if negated)
+		if ctxSQL == "" {
+			q.getBuilder(ctx).And(builder.Expr(constantSQL(!ctx.negate)))
+			return nil
+		}
+
+		// add conditions generated from the $and operation into the main builder
+		q.getBuilder(ctx).And(fieldExpr(ctx.negate, ctxSQL, args...))
+
+	case Or:
+		// an empty $or matches no row (or every row, if negated)
+		if len(n) == 0 {
+			q.getBuilder(ctx).And(builder.Expr(constantSQL(ctx.negate)))
+			return nil
+		}
+		if ctx.negate {
+			return q.buildNegated(n, ctx)
+		}
+		conditions := []builder.Cond{}
+		for _, stmt := range n {
+			ctxBuilder := new(builder.Builder)
 
-			// field is not an operator
-			if !anOperator {
-
-				// ensure the field name is valid
-				if !q.isValidField(field) {
-					return fmt.Errorf("unknown query field: %s", field)
-				}
-
-				// non-operator field can only have string, number of map value type
-				if !q.isString(fieldValue) && !q.isNumber(fieldValue) && !q.isMap(fieldValue) {
-					return fmt.Errorf("field '%s': invalid value type. expects string, number or map", field)
-				}
-
-				// when field value is a string, or number, add equality condition
-				if q.isString(fieldValue) || q.isNumber(fieldValue) {
-					q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s = ?", field), fieldValue))
-					continue
-				}
-
-				// at this point, the field value is a map
-				// ensure all map keys are valid operators
-				if err := q.validateCompareOperators(fieldValue); err != nil {
-					return fmt.Errorf("field '%s': %s", field, err)
-				}
-
-				for _op, _opVal := range fieldValue.(map[string]interface{}) {
-					op, opVal := _op, _opVal
-					switch op {
-					case "$eq":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$eq' operator supports only string and number type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s = ?", field), opVal))
-
-					case "$gt":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$gt' operator supports only number or string type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s > ?", field), opVal))
-
-					case "$gte":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$gte' operator supports only number or string type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s >= ?", field), opVal))
-
-					case "$lt":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$lt' operator supports only number or string type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s < ?", field), opVal))
-
-					case "$lte":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$lte' operator supports only number or string type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s <= ?", field), opVal))
-
-					case "$ne":
-						if !q.isString(opVal) && !q.isNumber(opVal) {
-							return fmt.Errorf("field '%s': '$ne' operator supports only number or string type", field)
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s <> ?", field), opVal))
-
-					case "$in":
-						if !q.isArray(opVal) {
-							return fmt.Errorf("field '%s': '$in' operator supports only array type", field)
-						}
-						values := opVal.([]interface{})
-						placeHolders := strings.TrimRight(strings.Repeat("?,", len(values)), ",")
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf(`%s IN (`+placeHolders+`)`, field), values...))
-
-					case "$nin":
-						if !q.isArray(opVal) {
-							return fmt.Errorf("field '%s': '$nin' operator supports only array type", field)
-						}
-						values := opVal.([]interface{})
-						placeHolders := strings.TrimRight(strings.Repeat("?,", len(values)), ",")
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf(`%s NOT IN (`+placeHolders+`)`, field), values...))
-
-					case "$sw":
-						if !q.isString(opVal) {
-							return fmt.Errorf("field '%s': '$sw' operator supports only string type", field)
-						}
-						value := opVal.(string)
-						if strings.Index(value, "%") != -1 || strings.Index(value, "_") != -1 {
-							return fmt.Errorf("field '%s': '$ew' string cannot contain these characters: %v", field, []string{"_", "%"})
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s LIKE ?", field), value+"%"))
-
-					case "$ew":
-						if !q.isString(opVal) {
-							return fmt.Errorf("field '%s': '$ew' operator supports only string type", field)
-						}
-						value := opVal.(string)
-						if strings.Index(value, "%") != -1 || strings.Index(value, "_") != -1 {
-							return fmt.Errorf("field '%s': '$ew' string cannot contain these characters: %v", field, []string{"_", "%"})
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s LIKE ?", field), "%"+value))
-
-					case "$ct":
-						if !q.isString(opVal) {
-							return fmt.Errorf("field '%s': '$ct' operator supports only string type", field)
-						}
-						value := opVal.(string)
-						if strings.Index(value, "%") != -1 || strings.Index(value, "_") != -1 {
-							return fmt.Errorf("field '%s': '$ct' string cannot contain these characters: %v", field, []string{"_", "%"})
-						}
-						q.getBuilder(ctx).And(fieldExpr(ctx.negate, fmt.Sprintf("%s LIKE ?", field), "%"+value+"%"))
-
-					case "$not":
-						if !q.isMap(opVal) {
-							return fmt.Errorf("field '%s': '$not' operator supports only map type", field)
-						}
-
-						// ensure only compare operators are included
-						for op := range _opVal.(map[string]interface{}) {
-							if !q.isValidOperator(op, compareOperators) {
-								return fmt.Errorf("bad value. unknown operator: %s", field)
-							}
-						}
-
-						// construct new express using the field and the $not operator value
-						// for direct comparison. eg: { field: { $not: { $eq: "xyz" }}} to { field: { $eq: "xyz" }}
-						normalizedExpr := map[string]interface{}{
-							field: opVal,
-						}
-
-						// parse the normalized expression and set negate to true in parser context
-						err := _parse(normalizedExpr, parserCtx{
-							b:      ctx.b,
-							negate: true,
-						})
-						if err != nil {
-							return err
-						}
-					}
-				}
-				continue
+			// parse statement. Set a custom builder for the parsers
+			if err := q.buildNode(stmt, parserCtx{b: ctxBuilder}); err != nil {
+				return err
 			}
 
-			// At this point, field is an operator.
-			// Field must be an array of expressions
-			if !q.isArray(fieldValue) {
-				return fmt.Errorf("field '%s': operator supports only array type", field)
+			// create condition from context builder
+			sql, args, err := contextSQL(ctxBuilder, false)
+			if err != nil {
+				return fmt.Errorf("failed to get sql from builder. %s", err)
 			}
 
-			switch field {
-			case "$and":
-				ctxBuilder := new(Builder)
-				for _, stmt := range fieldValue.([]interface{}) {
-
-					// statements must be maps
-					if !q.isMap(stmt) {
-						return fmt.Errorf("field '%s': '$and/$or' entries must be full objects", field)
-					}
-
-					// parse statement. Set a custom builder for the parsers
-					err := _parse(stmt.(map[string]interface{}), parserCtx{b: ctxBuilder})
-					if err != nil {
-						return err
-					}
-				}
-
-				// add conditions generated from the $and operation into the main builder
-				ctxSQL, args, err := ctxBuilder.ToSQL()
-				if err != nil {
-					return fmt.Errorf("failed to construct sql from context builder")
-				}
-
-				// add sql to main or context builder
-				q.getBuilder(ctx).And(Expr(ctxSQL, args...))
-
-			case "$or":
-				conditions := []Cond{}
-				for _, stmt := range fieldValue.([]interface{}) {
-					ctxBuilder := new(Builder)
-
-					// statements must be maps
-					if !q.isMap(stmt) {
-						return fmt.Errorf("field '%s': '$and/$or' entries must be full objects", field)
-					}
-
-					// parse statement. Set a custom builder for the parsers
-					err := _parse(stmt.(map[string]interface{}), parserCtx{b: ctxBuilder})
-					if err != nil {
-						return err
-					}
-
-					// create condition from context builder
-					sql, args, err := ctxBuilder.ToSQL()
-					if err != nil {
-						return fmt.Errorf("failed to get sql from builder. %s", err)
-					}
-
-					conditions = append(conditions, Expr(sql, args...))
-				}
-
-				// add conditions to main or context builder
-				q.getBuilder(ctx).And(Or(conditions...))
-
-			case "$nor":
-				conditions := []Cond{}
-				for _, stmt := range fieldValue.([]interface{}) {
-					ctxBuilder := new(Builder)
-
-					// statements must be maps
-					if !q.isMap(stmt) {
-						return fmt.Errorf("field '%s': '$and/$or' entries must be full objects", field)
-					}
-
-					// parse statement. Set a custom builder for the parsers and set negate to true
-					err := _parse(stmt.(map[string]interface{}), parserCtx{b: ctxBuilder, negate: true})
-					if err != nil {
-						return err
-					}
-
-					// create condition from context builder
-					sql, args, err := ctxBuilder.ToSQL()
-					if err != nil {
-						return fmt.Errorf("failed to get sql from builder. %s", err)
-					}
-
-					conditions = append(conditions, Expr(sql, args...))
-				}
-
-				// add conditions to main or context builder
-				q.getBuilder(ctx).And(And(conditions...))
+			conditions = append(conditions, builder.Expr(sql, args...))
+		}
+
+		// add conditions to main or context builder
+		q.getBuilder(ctx).And(builder.Or(conditions...))
+
+	case Nor:
+		if ctx.negate {
+			return q.buildNegated(n, ctx)
+		}
+		for _, stmt := range n {
+
+			// parse statement with negate set to true
+			if err := q.buildNode(stmt, parserCtx{b: q.getBuilder(ctx), negate: true}); err != nil {
+				return err
 			}
 		}
+
+	case nil:
+		return fmt.Errorf("unexpected nil node")
+
+	default:
+		return fmt.Errorf("unknown node type: %T", node)
+	}
+	return nil
+}
+
+// buildNegated adds the negated condition of a node to
+// the builder of the context
+func (q *JSQ) buildNegated(node Node, ctx parserCtx) error {
+	ctxBuilder := new(builder.Builder)
+	if err := q.buildNode(node, parserCtx{b: ctxBuilder}); err != nil {
+		return err
+	}
+	sql, args, err := contextSQL(ctxBuilder, false)
+	if err != nil {
+		return fmt.Errorf("failed to get sql from builder. %s", err)
+	}
+	q.getBuilder(ctx).And(fieldExpr(true, sql, args...))
+	return nil
+}
+
+// buildCompare validates a compare operator against the
+// field it is applied to and adds its condition to the
+// builder of the context
+func (q *JSQ) buildCompare(c Compare, ctx parserCtx) error {
+	field, op, opVal, negate := c.Field, c.Op, c.Value, ctx.negate
+	b := q.getBuilder(ctx)
+
+	// filter on a field of a related table
+	if name, ok := q.relationOf(field); ok {
+		cond, err := q.relationCond(negate, name, c)
+		if err != nil {
+			return err
+		}
+		b.And(cond)
 		return nil
 	}
 
-	return _parse(JSQ, parserCtx{})
+	insensitive, err := q.checkCompare(c)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "$eq":
+		if !q.isScalar(opVal) {
+			return fmt.Errorf("field '%s': '$eq' operator supports only string, number, boolean or null type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		b.And(q.equalCond(negate, field, value, insensitive))
+
+	case "$ieq":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$ieq' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(q.equalCond(negate, field, opVal, true))
+
+	case "$gt":
+		if !q.isString(opVal) && !q.isNumber(opVal) {
+			return fmt.Errorf("field '%s': '$gt' operator supports only number or string type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		b.And(q.compareCond(negate, field, ">", value))
+
+	case "$gte":
+		if !q.isString(opVal) && !q.isNumber(opVal) {
+			return fmt.Errorf("field '%s': '$gte' operator supports only number or string type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		b.And(q.compareCond(negate, field, ">=", value))
+
+	case "$lt":
+		if !q.isString(opVal) && !q.isNumber(opVal) {
+			return fmt.Errorf("field '%s': '$lt' operator supports only number or string type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		b.And(q.compareCond(negate, field, "<", value))
+
+	case "$lte":
+		if !q.isString(opVal) && !q.isNumber(opVal) {
+			return fmt.Errorf("field '%s': '$lte' operator supports only number or string type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		b.And(q.compareCond(negate, field, "<=", value))
+
+	case "$ne":
+		if !q.isScalar(opVal) {
+			return fmt.Errorf("field '%s': '$ne' operator supports only string, number, boolean or null type", field)
+		}
+		value, err := q.value(field, op, opVal)
+		if err != nil {
+			return err
+		}
+		if _, ok := value.(string); ok && insensitive {
+			b.And(q.equalCond(!negate, field, value, true))
+			return nil
+		}
+		if value != nil && q.isArrayField(field) {
+			b.And(q.containsCond(!negate, field, value))
+			return nil
+		}
+		b.And(q.compareCond(negate, field, "<>", value))
+
+	case "$in":
+		if !q.isArray(opVal) {
+			return fmt.Errorf("field '%s': '$in' operator supports only array type", field)
+		}
+		values, err := q.values(field, op, opVal.([]interface{}))
+		if err != nil {
+			return err
+		}
+		b.And(q.inCond(negate, field, values, false))
+
+	case "$nin":
+		if !q.isArray(opVal) {
+			return fmt.Errorf("field '%s': '$nin' operator supports only array type", field)
+		}
+		values, err := q.values(field, op, opVal.([]interface{}))
+		if err != nil {
+			return err
+		}
+		b.And(q.inCond(negate, field, values, true))
+
+	case "$sw":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$sw' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, q.dialect.Like(q.column(field), insensitive), q.dialect.EscapeLike(opVal.(string))+"%"))
+
+	case "$ew":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$ew' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, q.dialect.Like(q.column(field), insensitive), "%"+q.dialect.EscapeLike(opVal.(string))))
+
+	case "$ct":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$ct' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, q.dialect.Like(q.column(field), insensitive), "%"+q.dialect.EscapeLike(opVal.(string))+"%"))
+
+	case "$ict":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$ict' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, q.dialect.Like(q.column(field), true), "%"+q.dialect.EscapeLike(opVal.(string))+"%"))
+
+	case "$like":
+		if !q.allowLikePatterns {
+			return fmt.Errorf("field '%s': '$like' operator is not enabled", field)
+		}
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$like' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, q.dialect.Like(q.column(field), insensitive), opVal))
+
+	case "$regex":
+		if !q.isString(opVal) {
+			return fmt.Errorf("field '%s': '$regex' operator supports only string type", field)
+		}
+		if err := q.requireStringField(field, op); err != nil {
+			return err
+		}
+		expr, pattern, err := q.regexExpr(field, opVal.(string), insensitive)
+		if err != nil {
+			return err
+		}
+		b.And(fieldExpr(negate, expr, pattern))
+
+	case "$all":
+		if !q.isArray(opVal) {
+			return fmt.Errorf("field '%s': '$all' operator supports only array type", field)
+		}
+		values, err := q.values(field, op, opVal.([]interface{}))
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if v == nil {
+				return fmt.Errorf("field '%s': '$all' operator does not support null values", field)
+			}
+		}
+		b.And(q.allCond(negate, field, values))
+
+	case "$size":
+		size, ok := opVal.(float64)
+		if !ok || size < 0 || size != float64(int64(size)) {
+			return fmt.Errorf("field '%s': '$size' operator supports only non-negative integers", field)
+		}
+		b.And(q.sizeCond(negate, field, int64(size)))
+
+	case "$elemMatch":
+		elem, err := q.elemMatchNode(field, opVal)
+		if err != nil {
+			return err
+		}
+		cond, err := q.elemMatchCond(negate, field, elem)
+		if err != nil {
+			return err
+		}
+		b.And(cond)
+
+	case "$exists":
+		exists, ok := opVal.(bool)
+		if !ok {
+			return fmt.Errorf("field '%s': '$exists' operator supports only boolean type", field)
+		}
+		if exists {
+			b.And(q.compareCond(negate, field, "<>", nil))
+		} else {
+			b.And(q.compareCond(negate, field, "=", nil))
+		}
+
+	}
+	return nil
+}
+
+// checkCompare ensures the field and operator of a compare node
+// are valid and returns whether string operators ignore case
+func (q *JSQ) checkCompare(c Compare) (bool, error) {
+	field, op := c.Field, c.Op
+
+	// ensure the field name is valid
+	if !q.isValidField(field) {
+		if err := q.jsonPathError(field); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("unknown query field: %s", field)
+	}
+	if op == "$not" || op == "$options" || !q.isValidOperator(op, compareOperators) {
+		return false, fmt.Errorf("field '%s': bad value. unknown operator: %s", field, op)
+	}
+	if !q.isAllowedOperator(field, op) {
+		return false, fmt.Errorf("field '%s': operator not allowed: %s", field, op)
+	}
+	if err := q.checkArrayOperator(field, op); err != nil {
+		return false, err
+	}
+
+	// determine whether string operators ignore case
+	return q.caseInsensitive(field, c.Options)
 }
 
 // isArray checks whether an interface underlying type is an array
@@ -401,6 +827,18 @@ func (q *JSQ) isNumber(v interface{}) bool {
 	}
 }
 
+// isBool checks whether an interface underlying type is bool
+func (q *JSQ) isBool(v interface{}) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+// isScalar checks whether a value is a string,
+// number, boolean or null
+func (q *JSQ) isScalar(v interface{}) bool {
+	return v == nil || q.isString(v) || q.isNumber(v) || q.isBool(v)
+}
+
 // isMap checks whether an interface value is a map[string]interface{}
 func (q *JSQ) isMap(v interface{}) bool {
 	if _, ok := v.(map[string]interface{}); ok {
@@ -426,7 +864,7 @@ func (q *JSQ) validateCompareOperators(v interface{}) error {
 // isEmptyBuilder checks whether the builder is empty.
 // A builder with no condition will be empty
 func (q *JSQ) isEmptyBuilder() bool {
-	return q.b == nil || reflect.DeepEqual(q.b, new(Builder))
+	return q.b == nil || reflect.DeepEqual(q.b, new(builder.Builder))
 }
 
 // getSQL gets SQL from the builder
@@ -445,8 +883,25 @@ func (q *JSQ) getSQL() (string, []interface{}, error) {
 
 // ToSQL returns the generated SQL and arguments
 func (q *JSQ) ToSQL() (string, []interface{}, error) {
-	if q.isEmptyBuilder() {
-		return "", nil, nil
+	sql, args, err := q.whereSQL()
+	if err != nil {
+		return "", nil, err
+	}
+	return rebind(q.dialect, sql), args, nil
+}
+
+// ToSQLFor returns the SQL and arguments of the last parsed
+// query generated for the given dialect
+func (q *JSQ) ToSQLFor(dialect Dialect) (string, []interface{}, error) {
+	if dialect == nil {
+		dialect = Generic
+	}
+	c := *q
+	c.dialect = dialect
+	if q.b != nil {
+		if err := c.build(q.node); err != nil {
+			return "", nil, err
+		}
 	}
-	return q.b.ToSQL()
+	return c.ToSQL()
 }
@@ -65,6 +65,7 @@ func TestJSQ(t *testing.T) {
 	}
 
 	jsq := NewJSQ(nil)
+	jsq.AllowAnyField(true)
 
 	engine.CreateTables(Person{})
 
@@ -80,11 +81,25 @@ func TestJSQ(t *testing.T) {
 		})
 
 		Convey(".isValidField", func() {
-			Convey("when no field is whitelisted, all fields are allowed", func() {
+			Convey("when any field is allowed, all valid identifiers are allowed", func() {
 				So(jsq.isValidField("name"), ShouldEqual, true)
 				So(jsq.isValidField("unknown"), ShouldEqual, true)
 			})
 
+			Convey("when no field is whitelisted and any field is not allowed, all fields are invalid", func() {
+				jsq := NewJSQ(nil)
+				So(jsq.isValidField("name"), ShouldEqual, false)
+			})
+
+			Convey("fields that are not valid identifiers are invalid", func() {
+				So(jsq.isValidField("1=1 OR name"), ShouldEqual, false)
+				So(jsq.isValidField("name;"), ShouldEqual, false)
+				So(jsq.isValidField("1name"), ShouldEqual, false)
+				So(jsq.isValidField(""), ShouldEqual, false)
+				jsq := NewJSQ([]string{"na me"})
+				So(jsq.isValidField("na me"), ShouldEqual, false)
+			})
+
 			Convey("when fields are whitelisted, unknown fields are invalid", func() {
 				jsq := NewJSQ([]string{"name"})
 				So(jsq.isValidField("name"), ShouldEqual, true)
@@ -300,6 +315,12 @@ func TestJSQ(t *testing.T) {
 					So(err.Error(), ShouldEqual, "field 'name': '$not' operator supports only map type")
 				})
 
+				Convey("Should return error when assigned an empty map", func() {
+					err := jsq.Parse(`{"name": { "$not": {}}}`)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "field 'name': '$not' operator expects at least one operator")
+				})
+
 				Convey("Should return all persons with name not equal to ben", func() {
 					err := jsq.Parse(`{"name": { "$not": { "$eq": "ben" }}}`)
 					So(err, ShouldBeNil)
@@ -536,3 +557,202 @@ func TestJSQ(t *testing.T) {
 		})
 	})
 }
+
+func TestParseSQL(t *testing.T) {
+	Convey("Parse", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		jsq.AllowAnyField(true)
+
+		toSQL := func(query string) (string, []interface{}) {
+			err := jsq.Parse(query)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			return sql, args
+		}
+
+		Convey("booleans and null", func() {
+			Convey("Should compile booleans to boolean literals", func() {
+				sql, args := toSQL(`{"active": true}`)
+				So(sql, ShouldEqual, `"active" = TRUE`)
+				So(args, ShouldBeEmpty)
+			})
+
+			Convey("Should compile null equality to IS NULL", func() {
+				sql, _ := toSQL(`{"deleted_at": null}`)
+				So(sql, ShouldEqual, `"deleted_at" IS NULL`)
+				sql, _ = toSQL(`{"deleted_at": { "$eq": null }}`)
+				So(sql, ShouldEqual, `"deleted_at" IS NULL`)
+			})
+
+			Convey("Should compile null inequality to IS NOT NULL", func() {
+				sql, _ := toSQL(`{"deleted_at": { "$ne": null }}`)
+				So(sql, ShouldEqual, `"deleted_at" IS NOT NULL`)
+			})
+
+			Convey("Should return error if null is used with a range operator", func() {
+				err := jsq.Parse(`{"age": { "$gt": null }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': '$gt' operator supports only number or string type")
+			})
+
+			Convey("Should match nulls in $in and $nin", func() {
+				sql, args := toSQL(`{"age": { "$in": [21, null] }}`)
+				So(sql, ShouldEqual, `("age" IN ($1) OR "age" IS NULL)`)
+				So(args, ShouldResemble, []interface{}{float64(21)})
+				sql, _ = toSQL(`{"age": { "$nin": [null] }}`)
+				So(sql, ShouldEqual, `"age" IS NOT NULL`)
+			})
+
+			Convey("Should compile an empty $in to a false condition", func() {
+				sql, _ := toSQL(`{"age": { "$in": [] }}`)
+				So(sql, ShouldEqual, `1 = 0`)
+			})
+		})
+
+		Convey("$exists", func() {
+			sql, _ := toSQL(`{"deleted_at": { "$exists": true }}`)
+			So(sql, ShouldEqual, `"deleted_at" IS NOT NULL`)
+			sql, _ = toSQL(`{"deleted_at": { "$exists": false }}`)
+			So(sql, ShouldEqual, `"deleted_at" IS NULL`)
+			sql, _ = toSQL(`{"deleted_at": { "$not": { "$exists": false }}}`)
+			So(sql, ShouldEqual, `NOT ("deleted_at" IS NULL)`)
+
+			err := jsq.Parse(`{"deleted_at": { "$exists": 1 }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'deleted_at': '$exists' operator supports only boolean type")
+		})
+
+		Convey("pattern operators", func() {
+			Convey("Should escape wildcards in $sw, $ew and $ct", func() {
+				sql, args := toSQL(`{"file_name": { "$ct": "report_2024" }}`)
+				So(sql, ShouldEqual, `"file_name" LIKE $1 ESCAPE '\'`)
+				So(args, ShouldResemble, []interface{}{`%report\_2024%`})
+				_, args = toSQL(`{"code": { "$sw": "100%" }}`)
+				So(args, ShouldResemble, []interface{}{`100\%%`})
+				_, args = toSQL(`{"code": { "$ew": "a\\b" }}`)
+				So(args, ShouldResemble, []interface{}{`%a\\b`})
+			})
+
+			Convey("$like", func() {
+				Convey("Should return error if not enabled", func() {
+					err := jsq.Parse(`{"name": { "$like": "b_n%" }}`)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "field 'name': '$like' operator is not enabled")
+				})
+
+				Convey("Should pass raw patterns when enabled", func() {
+					jsq.AllowLikePatterns(true)
+					sql, args := toSQL(`{"name": { "$like": "b_n%" }}`)
+					So(sql, ShouldEqual, `"name" LIKE $1 ESCAPE '\'`)
+					So(args, ShouldResemble, []interface{}{"b_n%"})
+				})
+			})
+		})
+
+		Convey("case-insensitive operators", func() {
+			Convey("Should ignore case when $options contains 'i'", func() {
+				sql, args := toSQL(`{"name": { "$eq": "Ben", "$options": "i" }}`)
+				So(sql, ShouldEqual, `LOWER("name") = LOWER($1)`)
+				So(args, ShouldResemble, []interface{}{"Ben"})
+				sql, _ = toSQL(`{"name": { "$sw": "Be", "$options": "i" }}`)
+				So(sql, ShouldEqual, `"name" ILIKE $1 ESCAPE '\'`)
+				sql, _ = toSQL(`{"name": { "$ne": "Ben", "$options": "i" }}`)
+				So(sql, ShouldEqual, `NOT (LOWER("name") = LOWER($1))`)
+			})
+
+			Convey("Should support $ieq and $ict", func() {
+				sql, _ := toSQL(`{"name": { "$ieq": "Ben" }}`)
+				So(sql, ShouldEqual, `LOWER("name") = LOWER($1)`)
+				sql, args := toSQL(`{"name": { "$ict": "EN" }}`)
+				So(sql, ShouldEqual, `"name" ILIKE $1 ESCAPE '\'`)
+				So(args, ShouldResemble, []interface{}{"%EN%"})
+			})
+
+			Convey("Should use the case sensitivity of the field by default", func() {
+				err := jsq.SetFields(FieldMap{"name": {CaseInsensitive: true}})
+				So(err, ShouldBeNil)
+				sql, _ := toSQL(`{"name": "Ben"}`)
+				So(sql, ShouldEqual, `LOWER("name") = LOWER($1)`)
+				sql, _ = toSQL(`{"name": { "$eq": "Ben", "$options": "" }}`)
+				So(sql, ShouldEqual, `"name" = $1`)
+			})
+
+			Convey("Should return error if there is no operator to apply options to", func() {
+				for _, query := range []string{
+					`{"name": { "$options": "i" }}`,
+					`{"name": { "$not": { "$regex": "^b" }, "$options": "i" }}`,
+				} {
+					err := jsq.Parse(query)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "field 'name': '$options' operator requires a string operator such as '$regex'")
+				}
+			})
+
+			Convey("Should return error if an option is unknown", func() {
+				err := jsq.Parse(`{"name": { "$eq": "Ben", "$options": "z" }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': unknown option: z")
+			})
+		})
+
+		Convey("$regex", func() {
+			Convey("Should compile to ~ and ~* on Postgres", func() {
+				sql, args := toSQL(`{"name": { "$regex": "^be.*n$" }}`)
+				So(sql, ShouldEqual, `"name" ~ $1`)
+				So(args, ShouldResemble, []interface{}{"^be.*n$"})
+				sql, _ = toSQL(`{"name": { "$regex": "^be.*n$", "$options": "i" }}`)
+				So(sql, ShouldEqual, `"name" ~* $1`)
+			})
+
+			Convey("Should translate for other dialects", func() {
+				err := jsq.Parse(`{"name": { "$regex": "^be", "$options": "i" }}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.ToSQLFor(MySQL)
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, "`name` REGEXP ?")
+				So(args, ShouldResemble, []interface{}{"(?i)^be"})
+				sql, args, err = jsq.ToSQLFor(SQLite)
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"name" REGEXP ?`)
+				So(args, ShouldResemble, []interface{}{"(?i)^be"})
+				_, _, err = jsq.ToSQLFor(SQLServer)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': regular expressions are not supported by the sqlserver dialect")
+			})
+
+			Convey("Should return error if the pattern is not valid RE2", func() {
+				err := jsq.Parse(`{"name": { "$regex": "(a" }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': '$regex' pattern is invalid")
+			})
+
+			Convey("Should return error if the pattern is too long", func() {
+				jsq.SetMaxRegexLength(3)
+				err := jsq.Parse(`{"name": { "$regex": "abcd" }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': '$regex' pattern exceeds maximum length of 3")
+			})
+
+			Convey("Should return error if the dialect cannot execute a construct", func() {
+				err := jsq.Parse(`{"name": { "$regex": "(?P<first>a)" }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': pattern construct '(?P<' is not supported by the postgres dialect")
+			})
+		})
+
+		Convey("Empty logical entries", func() {
+			Convey("Should match every row in $and and $or", func() {
+				sql, _ := toSQL(`{"$or": [{"name": "ben"}, {}]}`)
+				So(sql, ShouldEqual, `("name" = $1 OR 1 = 1)`)
+				sql, _ = toSQL(`{"$and": [{}]}`)
+				So(sql, ShouldEqual, `1 = 1`)
+			})
+
+			Convey("Should match no row in $nor", func() {
+				sql, _ := toSQL(`{"$nor": [{}]}`)
+				So(sql, ShouldEqual, `1 = 0`)
+			})
+		})
+	})
+}
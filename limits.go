@@ -0,0 +1,233 @@
+package jsq
+
+import "fmt"
+
+// Names of the limits reported by LimitError
+const (
+	LimitDepth         = "depth"
+	LimitPredicates    = "predicates"
+	LimitInValues      = "in values"
+	LimitStringLength  = "string length"
+	LimitPatternLength = "pattern length"
+	LimitInputBytes    = "input bytes"
+)
+
+// Limits caps the complexity of queries, e.g. to accept queries from
+// untrusted clients. A limit of zero or less is not enforced.
+type Limits struct {
+
+	// MaxDepth is the maximum nesting of logical operators
+	// ($and, $or, $nor and $not) and of the operators of $expr
+	// expressions. Fields compared in the same object are nested
+	// in an implicit $and.
+	MaxDepth int
+
+	// MaxPredicates is the maximum number of compare operators,
+	// including those of $elemMatch, and $expr expressions
+	MaxPredicates int
+
+	// MaxInValues is the maximum number of values of $in and $nin
+	MaxInValues int
+
+	// MaxStringLength is the maximum length of string values,
+	// including the values of inserted and updated documents
+	MaxStringLength int
+
+	// MaxPatternLength is the maximum length of $like and $regex
+	// patterns. $regex patterns are also limited by SetMaxRegexLength.
+	MaxPatternLength int
+
+	// MaxInputBytes is the maximum size of the JSON input
+	// of a query, pipeline, find options or write
+	MaxInputBytes int
+}
+
+// LimitError is returned when a query exceeds a limit
+type LimitError struct {
+
+	// Limit is the name of the exceeded limit, e.g. LimitDepth
+	Limit string
+
+	// Max is the value of the limit
+	Max int
+
+	// Field and Op are the field and operator that
+	// exceed the limit, if the limit applies to values
+	Field string
+	Op    string
+}
+
+// Error returns a description of the exceeded limit
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitDepth:
+		return fmt.Sprintf("query exceeds maximum depth of %d", e.Max)
+	case LimitPredicates:
+		return fmt.Sprintf("query exceeds maximum of %d predicates", e.Max)
+	case LimitInValues:
+		return fmt.Sprintf("field '%s': '%s' operator exceeds maximum of %d values", e.Field, e.Op, e.Max)
+	case LimitStringLength:
+		return fmt.Sprintf("field '%s': string value exceeds maximum length of %d", e.Field, e.Max)
+	case LimitPatternLength:
+		return fmt.Sprintf("field '%s': '%s' pattern exceeds maximum length of %d", e.Field, e.Op, e.Max)
+	case LimitInputBytes:
+		return fmt.Sprintf("query exceeds maximum size of %d bytes", e.Max)
+	}
+	return fmt.Sprintf("query exceeds %s limit of %d", e.Limit, e.Max)
+}
+
+// SetLimits sets the limits that queries are checked against
+func (q *JSQ) SetLimits(limits Limits) {
+	q.limits = limits
+}
+
+// exceeds checks whether a value exceeds a limit
+func exceeds(n, max int) bool {
+	return max > 0 && n > max
+}
+
+// checkInputLimit ensures a JSON query does not exceed the maximum size
+func (q *JSQ) checkInputLimit(data []byte) error {
+	if exceeds(len(data), q.limits.MaxInputBytes) {
+		return &LimitError{Limit: LimitInputBytes, Max: q.limits.MaxInputBytes}
+	}
+	return nil
+}
+
+// checkLimits ensures an AST does not exceed the limits of the query
+func (q *JSQ) checkLimits(node Node) error {
+	var predicates int
+	return q.checkNodeLimits(node, 0, &predicates)
+}
+
+// checkNodeLimits checks the limits of a node nested in depth
+// logical nodes and counts its predicates
+func (q *JSQ) checkNodeLimits(node Node, depth int, predicates *int) error {
+	var children []Node
+	switch n := node.(type) {
+	case And:
+		children = n
+	case Or:
+		children = n
+	case Nor:
+		children = n
+	case Not:
+		children = []Node{n.Node}
+	case Compare:
+		if err := q.countPredicate(predicates); err != nil {
+			return err
+		}
+		if elem, ok := n.Value.(Node); ok && n.Op == "$elemMatch" {
+			return q.checkNodeLimits(elem, depth, predicates)
+		}
+		return q.checkCompareLimits(n)
+	case Expr:
+		if err := q.countPredicate(predicates); err != nil {
+			return err
+		}
+		if err := q.checkDepthLimit(depth + exprDepth(n.Value)); err != nil {
+			return err
+		}
+		return q.checkStringLimit("$expr", n.Value)
+	default:
+		return nil
+	}
+
+	if err := q.checkDepthLimit(depth + 1); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := q.checkNodeLimits(child, depth+1, predicates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDepthLimit ensures a nesting depth
+// does not exceed the maximum
+func (q *JSQ) checkDepthLimit(depth int) error {
+	if exceeds(depth, q.limits.MaxDepth) {
+		return &LimitError{Limit: LimitDepth, Max: q.limits.MaxDepth}
+	}
+	return nil
+}
+
+// countPredicate counts a predicate and ensures the
+// number of predicates does not exceed the maximum
+func (q *JSQ) countPredicate(predicates *int) error {
+	*predicates++
+	if exceeds(*predicates, q.limits.MaxPredicates) {
+		return &LimitError{Limit: LimitPredicates, Max: q.limits.MaxPredicates}
+	}
+	return nil
+}
+
+// exprDepth returns the nesting of the operators of an expression
+func exprDepth(v interface{}) int {
+	var depth int
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if d := exprDepth(e); d > depth {
+				depth = d
+			}
+		}
+		return depth
+	case map[string]interface{}:
+		for _, arg := range v {
+			if d := exprDepth(arg); d > depth {
+				depth = d
+			}
+		}
+	case *object:
+		for _, arg := range v.values {
+			if d := exprDepth(arg); d > depth {
+				depth = d
+			}
+		}
+	default:
+		return 0
+	}
+	return depth + 1
+}
+
+// checkCompareLimits ensures the value of a compare node
+// does not exceed the limits of its operator
+func (q *JSQ) checkCompareLimits(c Compare) error {
+	switch c.Op {
+	case "$in", "$nin":
+		if values, ok := c.Value.([]interface{}); ok && exceeds(len(values), q.limits.MaxInValues) {
+			return &LimitError{Limit: LimitInValues, Max: q.limits.MaxInValues, Field: c.Field, Op: c.Op}
+		}
+	case "$like", "$regex":
+		if pattern, ok := c.Value.(string); ok && exceeds(len(pattern), q.limits.MaxPatternLength) {
+			return &LimitError{Limit: LimitPatternLength, Max: q.limits.MaxPatternLength, Field: c.Field, Op: c.Op}
+		}
+	}
+	return q.checkStringLimit(c.Field, c.Value)
+}
+
+// checkStringLimit ensures the strings of a
+// value do not exceed the maximum length
+func (q *JSQ) checkStringLimit(field string, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		if exceeds(len(v), q.limits.MaxStringLength) {
+			return &LimitError{Limit: LimitStringLength, Max: q.limits.MaxStringLength, Field: field}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if err := q.checkStringLimit(field, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			if err := q.checkStringLimit(field, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package jsq
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimits(t *testing.T) {
+	Convey("Limits", t, func() {
+		jsq := NewJSQWithDialect([]string{"name", "age"}, Postgres)
+		jsq.AllowLikePatterns(true)
+
+		limitError := func(err error) *LimitError {
+			So(err, ShouldNotBeNil)
+			So(err, ShouldHaveSameTypeAs, &LimitError{})
+			return err.(*LimitError)
+		}
+
+		Convey("Should not limit queries by default", func() {
+			err := jsq.Parse(`{"$and": [{"$or": [{"$nor": [{"name": {"$in": ["` + strings.Repeat("a", 1000) + `"]}}]}]}]}`)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should limit the nesting depth", func() {
+			jsq.SetLimits(Limits{MaxDepth: 2})
+			So(jsq.Parse(`{"$or": [{"name": "ben", "age": 21}]}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"$or": [{"$and": [{"name": "ben"}, {"age": {"$not": {"$gt": 21}}}]}]}`))
+			So(err.Limit, ShouldEqual, LimitDepth)
+			So(err.Max, ShouldEqual, 2)
+			So(err.Error(), ShouldEqual, "query exceeds maximum depth of 2")
+		})
+
+		Convey("Should reject deep queries while they are parsed", func() {
+			jsq.SetLimits(Limits{MaxDepth: 5, MaxInputBytes: 1 << 20})
+			err := limitError(jsq.Parse(deepQuery(4000)))
+			So(err.Limit, ShouldEqual, LimitDepth)
+
+			// the invalid operator past the limit is never reached
+			query := strings.Replace(deepQuery(10), `{"age": 1}`, `{"age": {"$bad": 1}}`, 1)
+			err = limitError(jsq.Parse(query))
+			So(err.Limit, ShouldEqual, LimitDepth)
+		})
+
+		Convey("Should count the nesting of $expr operators", func() {
+			jsq.SetLimits(Limits{MaxDepth: 2})
+			So(jsq.Parse(`{"$expr": {"$gt": ["$age", {"$add": ["$name", 1]}]}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"$expr": {"$gt": ["$age", {"$add": [{"$multiply": ["$name", 2]}, 1]}]}}`))
+			So(err.Limit, ShouldEqual, LimitDepth)
+
+			err = limitError(jsq.ParseNode(Or{Expr{Value: map[string]interface{}{
+				"$gt": []interface{}{"$age", map[string]interface{}{"$add": []interface{}{"$name", 1.0}}},
+			}}}))
+			So(err.Limit, ShouldEqual, LimitDepth)
+		})
+
+		Convey("Should limit the number of predicates", func() {
+			jsq.SetLimits(Limits{MaxPredicates: 2})
+			So(jsq.Parse(`{"name": "ben", "age": {"$gt": 21}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"name": "ben", "age": {"$gt": 21, "$lt": 30}}`))
+			So(err.Limit, ShouldEqual, LimitPredicates)
+			So(err.Error(), ShouldEqual, "query exceeds maximum of 2 predicates")
+
+			err = limitError(jsq.ParseNode(Or{
+				Expr{Value: map[string]interface{}{"$gt": []interface{}{"$age", 21.0}}},
+				Compare{Field: "name", Op: "$eq", Value: "ben"},
+				Compare{Field: "age", Op: "$eq", Value: 21.0},
+			}))
+			So(err.Limit, ShouldEqual, LimitPredicates)
+		})
+
+		Convey("Should count the predicates of $elemMatch", func() {
+			So(jsq.SetFields(FieldMap{"scores": {Type: TypeArray, Items: TypeInt}}), ShouldBeNil)
+			jsq.SetLimits(Limits{MaxPredicates: 2})
+			So(jsq.Parse(`{"scores": {"$elemMatch": {"$gt": 1}}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"scores": {"$elemMatch": {"$gt": 1, "$lt": 5}}}`))
+			So(err.Limit, ShouldEqual, LimitPredicates)
+
+			err = limitError(jsq.ParseNode(Compare{Field: "scores", Op: "$elemMatch", Value: And{
+				Compare{Field: "scores", Op: "$gt", Value: 1.0},
+				Compare{Field: "scores", Op: "$lt", Value: 5.0},
+			}}))
+			So(err.Limit, ShouldEqual, LimitPredicates)
+		})
+
+		Convey("Should limit the number of $in and $nin values", func() {
+			jsq.SetLimits(Limits{MaxInValues: 2})
+			So(jsq.Parse(`{"age": {"$in": [1, 2]}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"age": {"$nin": [1, 2, 3]}}`))
+			So(err.Limit, ShouldEqual, LimitInValues)
+			So(err.Field, ShouldEqual, "age")
+			So(err.Op, ShouldEqual, "$nin")
+			So(err.Error(), ShouldEqual, "field 'age': '$nin' operator exceeds maximum of 2 values")
+		})
+
+		Convey("Should limit the length of string values", func() {
+			jsq.SetLimits(Limits{MaxStringLength: 3})
+			So(jsq.Parse(`{"name": {"$in": ["ben", "joe"]}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"name": {"$in": ["ben", "john"]}}`))
+			So(err.Limit, ShouldEqual, LimitStringLength)
+			So(err.Error(), ShouldEqual, "field 'name': string value exceeds maximum length of 3")
+
+			err = limitError(jsq.Parse(`{"$expr": {"$eq": ["$name", "john"]}}`))
+			So(err.Error(), ShouldEqual, "field '$expr': string value exceeds maximum length of 3")
+		})
+
+		Convey("Should limit the length of inserted and updated values", func() {
+			jsq.SetLimits(Limits{MaxStringLength: 3})
+			So(jsq.ParseInsert(`{"name": "ben"}`), ShouldBeNil)
+			So(jsq.ParseUpdate(`{"$set": {"name": "ben"}}`), ShouldBeNil)
+
+			err := limitError(jsq.ParseInsert(`[{"name": "ben"}, {"name": "john"}]`))
+			So(err.Error(), ShouldEqual, "field 'name': string value exceeds maximum length of 3")
+
+			err = limitError(jsq.ParseUpdate(`{"$set": {"name": "john"}}`))
+			So(err.Error(), ShouldEqual, "field 'name': string value exceeds maximum length of 3")
+
+			err = limitError(jsq.ParseUpdate(`{"$max": {"name": "john"}}`))
+			So(err.Limit, ShouldEqual, LimitStringLength)
+
+			err = limitError(jsq.ParseUpdateRequest(`{"filter": {"age": 30}, "update": {"$set": {"name": "john"}}, "upsert": true}`))
+			So(err.Limit, ShouldEqual, LimitStringLength)
+		})
+
+		Convey("Should limit the length of patterns", func() {
+			jsq.SetLimits(Limits{MaxPatternLength: 3, MaxStringLength: 10})
+			So(jsq.Parse(`{"name": {"$sw": "benjamin"}}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"name": {"$like": "b%n%"}}`))
+			So(err.Limit, ShouldEqual, LimitPatternLength)
+			So(err.Error(), ShouldEqual, "field 'name': '$like' pattern exceeds maximum length of 3")
+
+			err = limitError(jsq.Parse(`{"name": {"$regex": "^ben"}}`))
+			So(err.Error(), ShouldEqual, "field 'name': '$regex' pattern exceeds maximum length of 3")
+
+			jsq.SetLimits(Limits{})
+			jsq.SetMaxRegexLength(2)
+			err = limitError(jsq.Parse(`{"name": {"$regex": "^ben"}}`))
+			So(err.Error(), ShouldEqual, "field 'name': '$regex' pattern exceeds maximum length of 2")
+		})
+
+		Convey("Should limit the size of the input", func() {
+			jsq.SetLimits(Limits{MaxInputBytes: 16})
+			So(jsq.Parse(`{"name": "ben"}`), ShouldBeNil)
+
+			err := limitError(jsq.Parse(`{"name": "benjamin"}`))
+			So(err.Limit, ShouldEqual, LimitInputBytes)
+			So(err.Error(), ShouldEqual, "query exceeds maximum size of 16 bytes")
+
+			err = limitError(jsq.ParsePipeline(`[{"$match": {"name": "ben"}}]`))
+			So(err.Limit, ShouldEqual, LimitInputBytes)
+
+			err = limitError(jsq.ParseInsert(`{"name": "benjamin"}`))
+			So(err.Limit, ShouldEqual, LimitInputBytes)
+
+			err = limitError(jsq.ParseUpdate(`{"$set": {"name": "ben"}}`))
+			So(err.Limit, ShouldEqual, LimitInputBytes)
+
+			err = limitError(jsq.ParseOptions(`{"sort": {"name": 1}}`))
+			So(err.Limit, ShouldEqual, LimitInputBytes)
+		})
+
+		Convey("Should apply to compiled queries and templates", func() {
+			jsq.SetLimits(Limits{MaxStringLength: 3})
+			compiler := jsq.Compiler()
+
+			_, err := compiler.Compile(`{"name": "john"}`)
+			So(limitError(err).Limit, ShouldEqual, LimitStringLength)
+
+			tmpl, err := compiler.Template(`{"name": {"$eq": {"$param": "name"}}}`)
+			So(err, ShouldBeNil)
+			_, err = tmpl.Bind(map[string]interface{}{"name": "john"})
+			So(limitError(err).Limit, ShouldEqual, LimitStringLength)
+		})
+	})
+}
@@ -0,0 +1,358 @@
+package jsq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortKey describes a key to sort the rows of a query by
+type SortKey struct {
+
+	// Field is the public name of the field
+	Field string
+
+	// Desc sorts in descending order
+	Desc bool
+
+	// Nulls is "first" or "last" to place null values
+	// at the start or end, or empty for the default of
+	// the database
+	Nulls string
+}
+
+// FindOptions describes how the rows that match
+// a query are sorted, paged and projected
+type FindOptions struct {
+
+	// Sort holds the sort keys in order of precedence
+	Sort []SortKey
+
+	// Limit is the maximum number of rows. Zero means no limit.
+	Limit int
+
+	// Skip is the number of rows to skip
+	Skip int
+
+	// Projection holds the public names of the fields to
+	// return. If empty, all columns are returned.
+	Projection []string
+
+	// After holds the values of the sort keys of the row
+	// to continue after, as decoded from a cursor
+	After []interface{}
+}
+
+// ParseFind parses a find request in the form:
+//
+//	{"filter": {...}, "sort": ..., "limit": 10, "skip": 0, "projection": {...}}
+//
+// The filter is parsed as with Parse and the remaining keys as
+// with ParseOptions. If either is invalid, the query is unchanged.
+func (q *JSQ) ParseFind(jsonFind string) error {
+	if err := q.checkInputLimit([]byte(jsonFind)); err != nil {
+		return err
+	}
+	var find map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonFind), &find); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	options, err := q.parseOptions([]byte(jsonFind), "filter")
+	if err != nil {
+		return err
+	}
+
+	filter := []byte("{}")
+	if f, ok := find["filter"]; ok {
+		filter = f
+	}
+
+	// parse the filter into a copy, so that the
+	// query is only updated if the filter is valid
+	c := *q
+	if err := c.Parse(string(filter)); err != nil {
+		return err
+	}
+	c.options = options
+	*q = c
+	return nil
+}
+
+// ParseOptions parses find options in the form:
+//
+//	{"sort": {"age": -1, "name": 1}, "limit": 10, "skip": 20, "projection": {"name": 1}}
+//
+// A sort key value is 1 or "asc", -1 or "desc", or an object such as
+// {"order": -1, "nulls": "last"}. Sort keys may also be given as an
+// array of single-key objects. A projection includes (1) or excludes (0)
+// fields, but cannot mix both. A "cursor" created by EncodeCursor
+// selects the rows after the row it points to.
+func (q *JSQ) ParseOptions(jsonOptions string) error {
+	if err := q.checkInputLimit([]byte(jsonOptions)); err != nil {
+		return err
+	}
+	options, err := q.parseOptions([]byte(jsonOptions), "")
+	if err != nil {
+		return err
+	}
+	q.options = options
+	return nil
+}
+
+// Options returns the parsed find options
+func (q *JSQ) Options() FindOptions {
+	return q.options
+}
+
+// SetMaxLimit sets the maximum limit a query may request.
+// Queries without a limit are given the maximum limit.
+// A maximum of zero or less removes the restriction.
+func (q *JSQ) SetMaxLimit(n int) {
+	q.maxLimit = n
+}
+
+// parseOptions parses and validates find options in the order of
+// their keys, so that the first invalid option is always reported.
+// The key to skip, if set, is not an option.
+func (q *JSQ) parseOptions(data []byte, skip string) (FindOptions, error) {
+	var options FindOptions
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return options, fmt.Errorf("malformed json")
+	}
+	keys, err := orderedKeys(data)
+	if err != nil {
+		return options, fmt.Errorf("malformed json")
+	}
+
+	var cursor string
+	for _, key := range keys {
+		if key == skip {
+			continue
+		}
+		value := raw[key]
+		var err error
+		switch key {
+		case "cursor":
+			if json.Unmarshal(value, &cursor) != nil {
+				err = fmt.Errorf("cursor: expects a string")
+			}
+		case "sort":
+			options.Sort, err = q.parseSort(value)
+		case "limit":
+			options.Limit, err = parseCount(key, value)
+		case "skip":
+			options.Skip, err = parseCount(key, value)
+		case "projection":
+			options.Projection, err = q.parseProjection(value)
+		default:
+			err = fmt.Errorf("unknown option: %s", key)
+		}
+		if err != nil {
+			return FindOptions{}, err
+		}
+	}
+
+	if cursor != "" {
+		after, err := q.decodeCursor(cursor, options.Sort)
+		if err != nil {
+			return FindOptions{}, err
+		}
+		options.After = after
+	}
+
+	if q.maxLimit > 0 {
+		if options.Limit > q.maxLimit {
+			return FindOptions{}, fmt.Errorf("limit: must not exceed %d", q.maxLimit)
+		}
+		if options.Limit == 0 {
+			options.Limit = q.maxLimit
+		}
+	}
+
+	return options, nil
+}
+
+// parseCount parses a non-negative integer option
+func parseCount(key string, raw json.RawMessage) (int, error) {
+	var n float64
+	if err := json.Unmarshal(raw, &n); err != nil || n < 0 || n != float64(int(n)) {
+		return 0, fmt.Errorf("%s: expects a non-negative integer", key)
+	}
+	return int(n), nil
+}
+
+// parseSort parses a sort document or an array of sort documents
+func (q *JSQ) parseSort(raw json.RawMessage) ([]SortKey, error) {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(raw, &docs); err != nil {
+		docs = []json.RawMessage{raw}
+	}
+
+	var keys []SortKey
+	for _, doc := range docs {
+		fields, err := orderedKeys(doc)
+		if err != nil {
+			return nil, fmt.Errorf("sort: expects an object or an array of objects")
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal(doc, &values); err != nil {
+			return nil, fmt.Errorf("sort: expects an object or an array of objects")
+		}
+		for _, field := range fields {
+			if !q.isValidField(field) {
+				return nil, fmt.Errorf("sort: unknown field: %s", field)
+			}
+			key, err := parseSortKey(field, values[field])
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// parseSortKey parses the direction and null ordering of a sort key
+func parseSortKey(field string, v interface{}) (SortKey, error) {
+	key := SortKey{Field: field}
+	order := v
+	if m, ok := v.(map[string]interface{}); ok {
+		order = m["order"]
+		if nulls, ok := m["nulls"]; ok {
+			if nulls != "first" && nulls != "last" {
+				return key, fmt.Errorf("sort: field '%s': nulls must be 'first' or 'last'", field)
+			}
+			key.Nulls = nulls.(string)
+		}
+		for k := range m {
+			if k != "order" && k != "nulls" {
+				return key, fmt.Errorf("sort: field '%s': unknown key: %s", field, k)
+			}
+		}
+		if order == nil {
+			order = 1.0
+		}
+	}
+
+	switch order {
+	case 1.0, "asc":
+	case -1.0, "desc":
+		key.Desc = true
+	default:
+		return key, fmt.Errorf("sort: field '%s': order must be 1, -1, 'asc' or 'desc'", field)
+	}
+	return key, nil
+}
+
+// parseProjection parses a projection document into the
+// list of fields to return
+func (q *JSQ) parseProjection(raw json.RawMessage) ([]string, error) {
+	fields, err := orderedKeys(raw)
+	if err != nil {
+		return nil, fmt.Errorf("projection: expects an object")
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("projection: expects an object")
+	}
+
+	var include, exclude []string
+	for _, field := range fields {
+		if !q.isValidField(field) {
+			return nil, fmt.Errorf("projection: unknown field: %s", field)
+		}
+		switch values[field] {
+		case 1.0, true:
+			include = append(include, field)
+		case 0.0, false:
+			exclude = append(exclude, field)
+		default:
+			return nil, fmt.Errorf("projection: field '%s': expects 1 or 0", field)
+		}
+	}
+
+	if len(include) > 0 && len(exclude) > 0 {
+		return nil, fmt.Errorf("projection: cannot mix inclusion and exclusion")
+	}
+	if len(exclude) == 0 {
+		return include, nil
+	}
+	if q.allowAnyField {
+		return nil, fmt.Errorf("projection: exclusion requires a field whitelist")
+	}
+
+	// include every whitelisted field that is not excluded
+	names := make([]string, 0, len(q.fields))
+	for name := range q.fields {
+		excluded := false
+		for _, e := range exclude {
+			excluded = excluded || e == name
+		}
+		if !excluded {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ColumnsSQL returns the column list of the projection. Columns
+// whose names differ from their public field names are aliased.
+// It returns "*" if there is no projection.
+func (q *JSQ) ColumnsSQL() string {
+	if len(q.options.Projection) == 0 {
+		return "*"
+	}
+	columns := make([]string, len(q.options.Projection))
+	for i, field := range q.options.Projection {
+		columns[i] = q.column(field)
+		if f, _ := q.getField(field); f.Column != field && isValidIdentifier(field) {
+			columns[i] += " AS " + q.dialect.QuoteIdent(field)
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// OrderBySQL returns the ORDER BY clause of the sort
+// keys or an empty string if there is no sort key
+func (q *JSQ) OrderBySQL() string {
+	if len(q.options.Sort) == 0 {
+		return ""
+	}
+	keys := make([]string, len(q.options.Sort))
+	for i, key := range q.options.Sort {
+		keys[i] = q.dialect.OrderBy(q.column(key.Field), key.Desc, key.Nulls)
+	}
+	return "ORDER BY " + strings.Join(keys, ", ")
+}
+
+// LimitSQL returns the clause that applies the limit
+// and skip options or an empty string if neither is set
+func (q *JSQ) LimitSQL() string {
+	return q.dialect.LimitOffset(q.options.Limit, q.options.Skip)
+}
+
+// orderedKeys returns the keys of a json object in
+// the order they appear in the document
+func orderedKeys(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object")
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
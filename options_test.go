@@ -0,0 +1,142 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOptions(t *testing.T) {
+	Convey("FindOptions", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"name":       {},
+			"age":        {Column: "person_age"},
+			"created_at": {},
+			"password":   {},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".ParseOptions", func() {
+			Convey("Should parse sort keys in document order", func() {
+				err := jsq.ParseOptions(`{"sort": {"name": 1, "age": -1, "created_at": {"order": "desc", "nulls": "last"}}}`)
+				So(err, ShouldBeNil)
+				So(jsq.Options().Sort, ShouldResemble, []SortKey{
+					{Field: "name"},
+					{Field: "age", Desc: true},
+					{Field: "created_at", Desc: true, Nulls: "last"},
+				})
+				So(jsq.OrderBySQL(), ShouldEqual, `ORDER BY "name", "person_age" DESC, "created_at" DESC NULLS LAST`)
+			})
+
+			Convey("Should accept an array of sort documents", func() {
+				err := jsq.ParseOptions(`{"sort": [{"age": "asc"}, {"name": "desc"}]}`)
+				So(err, ShouldBeNil)
+				So(jsq.OrderBySQL(), ShouldEqual, `ORDER BY "person_age", "name" DESC`)
+			})
+
+			Convey("Should return error if a sort field is unknown", func() {
+				err := jsq.ParseOptions(`{"sort": {"unknown": 1}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "sort: unknown field: unknown")
+			})
+
+			Convey("Should return error if a sort order is invalid", func() {
+				err := jsq.ParseOptions(`{"sort": {"name": 2}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "sort: field 'name': order must be 1, -1, 'asc' or 'desc'")
+			})
+
+			Convey("Should parse limit and skip", func() {
+				err := jsq.ParseOptions(`{"limit": 10, "skip": 20}`)
+				So(err, ShouldBeNil)
+				So(jsq.LimitSQL(), ShouldEqual, "LIMIT 10 OFFSET 20")
+
+				err = jsq.ParseOptions(`{"limit": -1}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "limit: expects a non-negative integer")
+			})
+
+			Convey("Should enforce the maximum limit", func() {
+				jsq.SetMaxLimit(50)
+				err := jsq.ParseOptions(`{"limit": 100}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "limit: must not exceed 50")
+				err = jsq.ParseOptions(`{}`)
+				So(err, ShouldBeNil)
+				So(jsq.Options().Limit, ShouldEqual, 50)
+			})
+
+			Convey("Should parse an inclusive projection", func() {
+				err := jsq.ParseOptions(`{"projection": {"name": 1, "age": 1}}`)
+				So(err, ShouldBeNil)
+				So(jsq.ColumnsSQL(), ShouldEqual, `"name", "person_age" AS "age"`)
+			})
+
+			Convey("Should parse an exclusive projection", func() {
+				err := jsq.ParseOptions(`{"projection": {"password": 0}}`)
+				So(err, ShouldBeNil)
+				So(jsq.Options().Projection, ShouldResemble, []string{"age", "created_at", "name"})
+			})
+
+			Convey("Should return error if a projection mixes inclusion and exclusion", func() {
+				err := jsq.ParseOptions(`{"projection": {"name": 1, "password": 0}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "projection: cannot mix inclusion and exclusion")
+			})
+
+			Convey("Should return error if an option is unknown", func() {
+				err := jsq.ParseOptions(`{"order": 1}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown option: order")
+			})
+
+			Convey("Should report the first invalid option", func() {
+				for i := 0; i < 20; i++ {
+					err := jsq.ParseOptions(`{"limit": -1, "skip": -1, "order": 1}`)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "limit: expects a non-negative integer")
+				}
+			})
+		})
+
+		Convey(".ParseFind", func() {
+			err := jsq.ParseFind(`{"filter": {"name": "ben"}, "sort": {"age": -1}, "limit": 5}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"name" = $1`)
+			So(args, ShouldResemble, []interface{}{"ben"})
+			So(jsq.OrderBySQL(), ShouldEqual, `ORDER BY "person_age" DESC`)
+			So(jsq.LimitSQL(), ShouldEqual, "LIMIT 5")
+			So(jsq.ColumnsSQL(), ShouldEqual, "*")
+
+			Convey("Should leave the query unchanged if an option is invalid", func() {
+				err := jsq.ParseFind(`{"filter": {"age": 30}, "limit": -1}`)
+				So(err, ShouldNotBeNil)
+				sql, args, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"name" = $1`)
+				So(args, ShouldResemble, []interface{}{"ben"})
+				So(jsq.LimitSQL(), ShouldEqual, "LIMIT 5")
+			})
+
+			Convey("Should leave the query unchanged if the filter is invalid", func() {
+				err := jsq.ParseFind(`{"filter": {"unknown": 30}, "limit": 1}`)
+				So(err, ShouldNotBeNil)
+				sql, _, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"name" = $1`)
+				So(jsq.LimitSQL(), ShouldEqual, "LIMIT 5")
+			})
+		})
+
+		Convey("dialects", func() {
+			So(MySQL.OrderBy("`a`", false, "last"), ShouldEqual, "CASE WHEN `a` IS NULL THEN 1 ELSE 0 END, `a`")
+			So(MySQL.LimitOffset(0, 10), ShouldEqual, "LIMIT 18446744073709551615 OFFSET 10")
+			So(SQLServer.LimitOffset(10, 20), ShouldEqual, "OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY")
+			So(SQLite.LimitOffset(0, 10), ShouldEqual, "LIMIT -1 OFFSET 10")
+			So(Postgres.LimitOffset(0, 0), ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,264 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-xorm/builder"
+)
+
+// Relation describes a table that is related to the queried table.
+// Filters refer to the fields of a related table with the name of the
+// relation as a prefix, e.g. {"customer.country": "NG"}.
+type Relation struct {
+
+	// Local is the column of the queried table that joins the
+	// relation, qualified by the table (e.g. orders.customer_id)
+	Local string
+
+	// Foreign is the column of the related table that Local
+	// references, qualified by the table (e.g. customers.id)
+	Foreign string
+
+	// Fields holds the whitelisted fields of the related table
+	Fields FieldMap
+}
+
+// RelationMap maps relation names to their definitions
+type RelationMap map[string]Relation
+
+// relation is a resolved Relation
+type relation struct {
+	localTable, localColumn string
+	table, column           string
+
+	// fields holds the fields of the related table keyed by
+	// their prefixed names and qualified by the relation name
+	fields FieldMap
+}
+
+// copyRelations returns a copy of resolved relations
+// that does not share their field maps
+func copyRelations(relations map[string]relation) map[string]relation {
+	if relations == nil {
+		return nil
+	}
+	copied := make(map[string]relation, len(relations))
+	for name, rel := range relations {
+		rel.fields = copyFields(rel.fields)
+		copied[name] = rel
+	}
+	return copied
+}
+
+// SetRelations replaces the relations of the queried table. It returns
+// error if a name, table or column is not a valid identifier.
+func (q *JSQ) SetRelations(relations RelationMap) error {
+	resolved := map[string]relation{}
+	for name, r := range relations {
+		if !isValidIdentifier(name) {
+			return fmt.Errorf("relation '%s': invalid name", name)
+		}
+		localTable, localColumn, ok := splitQualified(r.Local)
+		if !ok {
+			return fmt.Errorf("relation '%s': local column must be qualified by its table, e.g. orders.customer_id", name)
+		}
+		table, column, ok := splitQualified(r.Foreign)
+		if !ok {
+			return fmt.Errorf("relation '%s': foreign column must be qualified by its table, e.g. customers.id", name)
+		}
+
+		rel := relation{
+			localTable:  localTable,
+			localColumn: localColumn,
+			table:       table,
+			column:      column,
+			fields:      FieldMap{},
+		}
+		for fieldName, f := range r.Fields {
+			if f.Table != "" {
+				return fmt.Errorf("relation '%s': field '%s': table is set by the relation", name, fieldName)
+			}
+			f, err := resolveField(fieldName, f)
+			if err != nil {
+				return fmt.Errorf("relation '%s': %s", name, err)
+			}
+			f.Table = name
+			rel.fields[name+"."+fieldName] = f
+		}
+		resolved[name] = rel
+	}
+	q.relations = resolved
+	return nil
+}
+
+// splitQualified splits a table qualified column
+func splitQualified(s string) (string, string, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 || !isValidIdentifier(parts[0]) || !isValidIdentifier(parts[1]) {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// relationOf returns the name of the relation a field belongs
+// to. Fields of the field map take precedence over relations.
+func (q *JSQ) relationOf(field string) (string, bool) {
+	if _, ok := q.fields[field]; ok {
+		return "", false
+	}
+	i := strings.Index(field, ".")
+	if i < 0 {
+		return "", false
+	}
+	if _, ok := q.relations[field[:i]]; !ok {
+		return "", false
+	}
+	return field[:i], true
+}
+
+// joinCond returns the condition that joins a relation
+// to the queried table
+func (q *JSQ) joinCond(name string) string {
+	rel := q.relations[name]
+	local := rel.localTable
+	if q.joinTable != "" {
+		local = q.joinTable
+	}
+	return fmt.Sprintf("%s.%s = %s.%s",
+		q.dialect.QuoteIdent(name), q.dialect.QuoteIdent(rel.column),
+		q.dialect.QuoteIdent(local), q.dialect.QuoteIdent(rel.localColumn))
+}
+
+// filteredRelations returns the names of the relations
+// the parsed filter refers to
+func (q *JSQ) filteredRelations() []string {
+	var names []string
+	seen := map[string]bool{}
+	Walk(q.node, func(n Node) bool {
+		if c, ok := n.(Compare); ok {
+			if name, ok := q.relationOf(c.Field); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// scopeTable returns the query to generate a statement over
+// table with an optional alias. The relations of the filter must
+// join the table, and their join conditions refer to the alias.
+func (q *JSQ) scopeTable(table, alias string) (*JSQ, error) {
+	names := q.filteredRelations()
+	if len(names) == 0 {
+		return q, nil
+	}
+	unqualified := table[strings.LastIndex(table, ".")+1:]
+	for _, name := range names {
+		if q.relations[name].localTable != unqualified {
+			return nil, fmt.Errorf("relation '%s' does not join table '%s'", name, table)
+		}
+	}
+	if alias == "" {
+		return q, nil
+	}
+	c := *q
+	c.joinTable = alias
+	if err := c.build(q.node); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// relationScope returns a copy of the query that
+// queries the fields of a relation
+func (q *JSQ) relationScope(name string) JSQ {
+	c := *q
+	c.fields = q.relations[name].fields
+	c.allowAnyField = false
+	c.relations = nil
+	c.unqualified = false
+	return c
+}
+
+// relationCond returns a condition that checks whether a related row
+// matches a compare operator on a field of the relation, as an EXISTS
+// subquery
+func (q *JSQ) relationCond(negate bool, name string, cmp Compare) (builder.Cond, error) {
+	rel := q.relations[name]
+	c := q.relationScope(name)
+	if err := c.build(cmp); err != nil {
+		return nil, err
+	}
+	sql, args, err := contextSQL(c.b, false)
+	if err != nil {
+		return nil, err
+	}
+	exists := fmt.Sprintf("EXISTS (SELECT 1 FROM %s %s WHERE %s AND %s)",
+		q.dialect.QuoteIdent(rel.table), q.dialect.QuoteIdent(name), q.joinCond(name), sql)
+	return fieldExpr(negate, exists, args...), nil
+}
+
+// lookupStage joins the relation named by the "as" key of a $lookup
+// stage to a level. The "from", "localField" and "foreignField" keys
+// are optional, but must match the relation if set.
+func (q *JSQ) lookupStage(level *aggLevel, spec json.RawMessage, table string) error {
+	var lookup map[string]string
+	if err := json.Unmarshal(spec, &lookup); err != nil {
+		return fmt.Errorf("$lookup: expects an object of strings")
+	}
+
+	name := lookup["as"]
+	rel, ok := q.relations[name]
+	if !ok {
+		return fmt.Errorf("$lookup: unknown relation: %s", name)
+	}
+	for key, v := range lookup {
+		var expected string
+		switch key {
+		case "as":
+			continue
+		case "from":
+			expected = rel.table
+		case "localField":
+			expected = rel.localColumn
+		case "foreignField":
+			expected = rel.column
+		default:
+			return fmt.Errorf("$lookup: unknown key: %s", key)
+		}
+		if v != expected {
+			return fmt.Errorf("$lookup: relation '%s': %s must be '%s'", name, key, expected)
+		}
+	}
+	if table != "" && table != rel.localTable {
+		return fmt.Errorf("$lookup: relation '%s' does not join table '%s'", name, table)
+	}
+	for _, joined := range level.joined {
+		if joined == name {
+			return fmt.Errorf("$lookup: relation '%s' is already joined", name)
+		}
+	}
+
+	// qualify the fields of the queried table, as the
+	// related table may have columns of the same name
+	fields := FieldMap{}
+	for fieldName, f := range level.fields {
+		if f.Table == "" {
+			f.Table = rel.localTable
+		}
+		fields[fieldName] = f
+	}
+	for fieldName, f := range rel.fields {
+		fields[fieldName] = f
+	}
+
+	level.fields = fields
+	level.joined = append(level.joined, name)
+	level.joins = append(level.joins, fmt.Sprintf("LEFT JOIN %s %s ON %s",
+		q.dialect.QuoteIdent(rel.table), q.dialect.QuoteIdent(name), q.joinCond(name)))
+	return nil
+}
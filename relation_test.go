@@ -0,0 +1,138 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRelation(t *testing.T) {
+	Convey("Relation", t, func() {
+		jsq := NewJSQWithDialect([]string{"id", "status", "amount"}, Postgres)
+		err := jsq.SetRelations(RelationMap{
+			"customer": {
+				Local:   "orders.customer_id",
+				Foreign: "customers.id",
+				Fields: FieldMap{
+					"country": {Type: TypeString},
+					"name":    {Column: "full_name", Type: TypeString},
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".SetRelations", func() {
+			Convey("Should return error if a column is not qualified", func() {
+				err := jsq.SetRelations(RelationMap{"customer": {Local: "customer_id", Foreign: "customers.id"}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "relation 'customer': local column must be qualified by its table, e.g. orders.customer_id")
+			})
+
+			Convey("Should return error if a field is invalid", func() {
+				err := jsq.SetRelations(RelationMap{"customer": {
+					Local:   "orders.customer_id",
+					Foreign: "customers.id",
+					Fields:  FieldMap{"name": {Column: "full name"}},
+				}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "relation 'customer': field 'name': invalid column name")
+			})
+		})
+
+		Convey("Should filter on related fields with EXISTS", func() {
+			err := jsq.Parse(`{"customer.country": "NG"}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM "customers" "customer" WHERE "customer"."id" = "orders"."customer_id" AND "customer"."country" = $1)`)
+			So(args, ShouldResemble, []interface{}{"NG"})
+		})
+
+		Convey("Should negate related filters", func() {
+			err := jsq.Parse(`{"$nor": [{"customer.name": {"$sw": "Ben"}}], "status": "paid"}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.Select("orders")
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `NOT (EXISTS (SELECT 1 FROM "customers" "customer" WHERE "customer"."id" = "orders"."customer_id" AND "customer"."full_name" LIKE $`)
+		})
+
+		Convey("Should join related filters to the alias of the table", func() {
+			err := jsq.Parse(`{"customer.country": "NG"}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.SelectAs("public.orders", "o")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "public"."orders" "o" WHERE EXISTS (SELECT 1 FROM "customers" "customer" `+
+				`WHERE "customer"."id" = "o"."customer_id" AND "customer"."country" = $1)`)
+
+			sql, _, err = jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `"customer"."id" = "orders"."customer_id"`)
+		})
+
+		Convey("Should return error if a related filter does not join the table", func() {
+			err := jsq.Parse(`{"customer.country": "NG"}`)
+			So(err, ShouldBeNil)
+			_, _, err = jsq.Select("invoices")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "relation 'customer' does not join table 'invoices'")
+
+			_, _, err = jsq.Delete("invoices")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "relation 'customer' does not join table 'invoices'")
+		})
+
+		Convey("Should return error if a related field is unknown", func() {
+			err := jsq.Parse(`{"customer.email": "a@b.c"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown query field: customer.email")
+		})
+
+		Convey("$lookup", func() {
+			Convey("Should join the relation", func() {
+				err := jsq.ParsePipeline(`[
+					{"$lookup": {"from": "customers", "localField": "customer_id", "foreignField": "id", "as": "customer"}},
+					{"$match": {"customer.country": "NG", "status": "paid"}},
+					{"$group": {"_id": "$customer.name", "total": {"$sum": "$amount"}}}
+				]`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Aggregate("orders")
+				So(err, ShouldBeNil)
+				So(sql, ShouldStartWith, `SELECT "customer"."full_name" AS "_id", SUM("orders"."amount") AS "total" FROM "orders" `+
+					`LEFT JOIN "customers" "customer" ON "customer"."id" = "orders"."customer_id" WHERE (`)
+				So(sql, ShouldContainSubstring, `"customer"."country" = $`)
+				So(sql, ShouldContainSubstring, `"orders"."status" = $`)
+				So(sql, ShouldEndWith, ` GROUP BY "customer"."full_name"`)
+			})
+
+			Convey("Should select the fields of the joined tables", func() {
+				err := jsq.ParsePipeline(`[{"$lookup": {"as": "customer"}}, {"$limit": 5}, {"$match": {"customer.country": "NG"}}]`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Aggregate("orders")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `SELECT * FROM (SELECT "orders"."amount" AS "amount", "customer"."country" AS "customer__country", `+
+					`"customer"."full_name" AS "customer__name", "orders"."id" AS "id", "orders"."status" AS "status" FROM "orders" `+
+					`LEFT JOIN "customers" "customer" ON "customer"."id" = "orders"."customer_id" LIMIT 5) "t1" WHERE ("customer__country" = $1)`)
+			})
+
+			Convey("Should return error if the relation is unknown", func() {
+				err := jsq.ParsePipeline(`[{"$lookup": {"from": "users", "as": "user"}}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$lookup: unknown relation: user")
+			})
+
+			Convey("Should return error if the lookup does not match the relation", func() {
+				err := jsq.ParsePipeline(`[{"$lookup": {"from": "users", "as": "customer"}}]`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$lookup: relation 'customer': from must be 'customers'")
+			})
+
+			Convey("Should return error if the relation does not join the table", func() {
+				err := jsq.ParsePipeline(`[{"$lookup": {"as": "customer"}}]`)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Aggregate("invoices")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$lookup: relation 'customer' does not join table 'invoices'")
+			})
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/util"
+)
+
+// FieldType is the type of the values of a field
+type FieldType string
+
+// Field types. A field without a type accepts
+// any scalar value as is. Null is accepted by all types.
+const (
+	TypeAny       FieldType = ""
+	TypeString    FieldType = "string"
+	TypeInt       FieldType = "int"
+	TypeFloat     FieldType = "float"
+	TypeDecimal   FieldType = "decimal"
+	TypeBool      FieldType = "bool"
+	TypeTimestamp FieldType = "timestamp"
+	TypeDate      FieldType = "date"
+	TypeUUID      FieldType = "uuid"
+	TypeEnum      FieldType = "enum"
+	TypeJSON      FieldType = "json"
+	TypeArray     FieldType = "array"
+	TypeJSONArray FieldType = "jsonarray"
+)
+
+var (
+	fieldTypes = []string{
+		string(TypeAny),
+		string(TypeString),
+		string(TypeInt),
+		string(TypeFloat),
+		string(TypeDecimal),
+		string(TypeBool),
+		string(TypeTimestamp),
+		string(TypeDate),
+		string(TypeUUID),
+		string(TypeEnum),
+		string(TypeJSON),
+		string(TypeArray),
+		string(TypeJSONArray),
+	}
+
+	decimalPattern = regexp.MustCompile(`^[-+]?[0-9]+(\.[0-9]+)?$`)
+	uuidPattern    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// dateLayout is the accepted format of date values
+const dateLayout = "2006-01-02"
+
+// isValidFieldType checks whether t is a known field type
+func isValidFieldType(t FieldType) bool {
+	return util.InStringSlice(fieldTypes, string(t))
+}
+
+// isStringType checks whether values of the field type are
+// plain strings that pattern matching operators can be applied to
+func isStringType(t FieldType) bool {
+	return t == TypeAny || t == TypeString || t == TypeEnum
+}
+
+// isArrayType checks whether values of the field type are arrays
+func isArrayType(t FieldType) bool {
+	return t == TypeArray || t == TypeJSONArray
+}
+
+// describeType returns a description of the values
+// expected by a field to use in error messages
+func describeType(f Field) string {
+	switch f.Type {
+	case TypeTimestamp:
+		return "RFC3339 timestamp"
+	case TypeDate:
+		return "date (YYYY-MM-DD)"
+	case TypeEnum:
+		return fmt.Sprintf("one of %v", f.Values)
+	case TypeJSONArray:
+		return "JSON array"
+	}
+	return string(f.Type)
+}
+
+// coerce validates a value against the type of a field and converts
+// compatible values to the type. It returns false if the value is
+// not acceptable.
+func coerce(f Field, v interface{}) (interface{}, bool) {
+	switch f.Type {
+	case TypeAny:
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, true
+			}
+			f, err := n.Float64()
+			return f, err == nil
+		}
+		return v, true
+
+	case TypeString:
+		s, ok := v.(string)
+		return s, ok
+
+	case TypeInt:
+		switch n := v.(type) {
+		case float64:
+			if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+				return nil, false
+			}
+			return int64(n), true
+		case int:
+			return int64(n), true
+		case int64:
+			return n, true
+		case json.Number:
+			i, err := n.Int64()
+			return i, err == nil
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+			return i, err == nil
+		}
+
+	case TypeFloat:
+		switch n := v.(type) {
+		case float64:
+			return n, true
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case json.Number:
+			f, err := n.Float64()
+			return f, err == nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			return f, err == nil
+		}
+
+	case TypeDecimal:
+		switch n := v.(type) {
+		case float64:
+			return strconv.FormatFloat(n, 'f', -1, 64), true
+		case int:
+			return strconv.Itoa(n), true
+		case int64:
+			return strconv.FormatInt(n, 10), true
+		case json.Number:
+			if decimalPattern.MatchString(n.String()) {
+				return n.String(), true
+			}
+			f, err := n.Float64()
+			return strconv.FormatFloat(f, 'f', -1, 64), err == nil
+		case string:
+			n = strings.TrimSpace(n)
+			return n, decimalPattern.MatchString(n)
+		}
+
+	case TypeBool:
+		switch b := v.(type) {
+		case bool:
+			return b, true
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			return parsed, err == nil
+		}
+
+	case TypeTimestamp:
+		if s, ok := v.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return t, err == nil
+		}
+
+	case TypeDate:
+		if s, ok := v.(string); ok {
+			t, err := time.Parse(dateLayout, s)
+			return t, err == nil
+		}
+
+	case TypeUUID:
+		if s, ok := v.(string); ok && uuidPattern.MatchString(s) {
+			return strings.ToLower(s), true
+		}
+
+	case TypeEnum:
+		if s, ok := v.(string); ok && util.InStringSlice(f.Values, s) {
+			return s, true
+		}
+
+	case TypeJSON:
+		bs, err := json.Marshal(v)
+		return string(bs), err == nil
+
+	case TypeJSONArray:
+		if _, ok := v.([]interface{}); ok {
+			bs, err := json.Marshal(v)
+			return string(bs), err == nil
+		}
+	}
+
+	return nil, false
+}
+
+// value validates and coerces the operand of an operator
+// against the type of a field
+func (q *JSQ) value(field, op string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	f, _ := q.getField(field)
+	if isArrayType(f.Type) {
+		f = elementField(f)
+	}
+	coerced, ok := coerce(f, v)
+	if !ok {
+		return nil, fmt.Errorf("field '%s': '%s' operator expects %s value", field, op, describeType(f))
+	}
+	return coerced, nil
+}
+
+// requireStringField ensures a pattern matching operator
+// is applied to a field whose values are strings
+func (q *JSQ) requireStringField(field, op string) error {
+	f, _ := q.getField(field)
+	if !isStringType(f.Type) {
+		return fmt.Errorf("field '%s': '%s' operator is not supported by %s fields", field, op, f.Type)
+	}
+	return nil
+}
+
+// values validates and coerces each operand of an array operator
+func (q *JSQ) values(field, op string, vs []interface{}) ([]interface{}, error) {
+	coerced := make([]interface{}, len(vs))
+	for i, v := range vs {
+		c, err := q.value(field, op, v)
+		if err != nil {
+			return nil, err
+		}
+		coerced[i] = c
+	}
+	return coerced, nil
+}
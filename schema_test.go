@@ -0,0 +1,108 @@
+package jsq
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchema(t *testing.T) {
+	Convey("Schema", t, func() {
+		jsq := NewJSQ(nil)
+		err := jsq.SetFields(FieldMap{
+			"name":       {Type: TypeString},
+			"age":        {Type: TypeInt},
+			"score":      {Type: TypeFloat},
+			"balance":    {Type: TypeDecimal},
+			"created_at": {Type: TypeTimestamp},
+			"birthday":   {Type: TypeDate},
+			"id":         {Type: TypeUUID},
+			"status":     {Type: TypeEnum, Values: []string{"active", "banned"}},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".SetFields", func() {
+			Convey("Should return error if type is unknown", func() {
+				err := jsq.SetFields(FieldMap{"age": {Type: "integer"}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': unknown type: integer")
+			})
+
+			Convey("Should return error if an enum has no values", func() {
+				err := jsq.SetFields(FieldMap{"status": {Type: TypeEnum}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'status': enum type requires values")
+			})
+		})
+
+		Convey("Should coerce numeric strings for int fields", func() {
+			err := jsq.Parse(`{"age": { "$gt": "21" }}`)
+			So(err, ShouldBeNil)
+			_, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{int64(21)})
+		})
+
+		Convey("Should reject mistyped values", func() {
+			err := jsq.Parse(`{"age": { "$gt": "abc" }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'age': '$gt' operator expects int value")
+
+			err = jsq.Parse(`{"age": 21.5}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'age': '$eq' operator expects int value")
+
+			err = jsq.Parse(`{"name": 10}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'name': '$eq' operator expects string value")
+
+			err = jsq.Parse(`{"status": { "$in": ["active", "deleted"] }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'status': '$in' operator expects one of [active banned] value")
+
+			err = jsq.Parse(`{"id": "not-a-uuid"}`)
+			So(err, ShouldNotBeNil)
+
+			err = jsq.Parse(`{"balance": "1.2.3"}`)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should coerce timestamps and dates to time.Time", func() {
+			err := jsq.Parse(`{"created_at": { "$gte": "2017-01-02T15:04:05Z" }, "birthday": "1990-05-01"}`)
+			So(err, ShouldBeNil)
+			_, args, err := jsq.ToSQL()
+			So(err, ShouldBeNil)
+			So(args, ShouldContain, time.Date(2017, 1, 2, 15, 4, 5, 0, time.UTC))
+			So(args, ShouldContain, time.Date(1990, 5, 1, 0, 0, 0, 0, time.UTC))
+
+			err = jsq.Parse(`{"created_at": "yesterday"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'created_at': '$eq' operator expects RFC3339 timestamp value")
+		})
+
+		Convey("Should reject pattern operators on non-string fields", func() {
+			err := jsq.Parse(`{"age": { "$sw": "2" }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'age': '$sw' operator is not supported by int fields")
+		})
+
+		Convey("coerce", func() {
+			v, ok := coerce(Field{Type: TypeDecimal}, float64(10.25))
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, "10.25")
+			v, ok = coerce(Field{Type: TypeFloat}, "1.5")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 1.5)
+			v, ok = coerce(Field{Type: TypeUUID}, "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")
+			v, ok = coerce(Field{Type: TypeBool}, "true")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, true)
+			v, ok = coerce(Field{Type: TypeJSON}, map[string]interface{}{"a": 1.0})
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, `{"a":1}`)
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package jsq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quoteTable validates and quotes a table name. The
+// name may be qualified by a schema (e.g. public.people).
+func (q *JSQ) quoteTable(table string) (string, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid table name: %s", table)
+	}
+	for i, part := range parts {
+		if !isValidIdentifier(part) {
+			return "", fmt.Errorf("invalid table name: %s", table)
+		}
+		parts[i] = q.dialect.QuoteIdent(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// whereSQL returns the filter, combined with the keyset predicate
+// of a cursor, with '?' placeholders. It returns an empty string if
+// there is no condition.
+func (q *JSQ) whereSQL() (string, []interface{}, error) {
+	filter, args, err := q.getSQL()
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyset, keysetArgs := q.keysetSQL()
+	switch {
+	case keyset == "":
+		return filter, args, nil
+	case filter == "":
+		return keyset, keysetArgs, nil
+	}
+	return fmt.Sprintf("(%s) AND %s", filter, keyset), append(args, keysetArgs...), nil
+}
+
+// Select returns a SELECT statement that returns the rows of
+// table that match the parsed filter, applying the projection,
+// sort, limit and skip options. Placeholders are written for the
+// dialect, so the statement can be passed to database/sql.
+func (q *JSQ) Select(table string) (string, []interface{}, error) {
+	return q.SelectAs(table, "")
+}
+
+// SelectAs is like Select but gives the table an alias. Use
+// it when fields are qualified by the alias (see Field.Table).
+func (q *JSQ) SelectAs(table, alias string) (string, []interface{}, error) {
+	from, err := q.quoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+	if alias != "" {
+		if !isValidIdentifier(alias) {
+			return "", nil, fmt.Errorf("invalid table alias: %s", alias)
+		}
+		from += " " + q.dialect.QuoteIdent(alias)
+	}
+
+	scoped, err := q.scopeTable(table, alias)
+	if err != nil {
+		return "", nil, err
+	}
+	where, args, err := scoped.whereSQL()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stmt := []string{"SELECT", q.ColumnsSQL(), "FROM", from}
+	if where != "" {
+		stmt = append(stmt, "WHERE", where)
+	}
+
+	orderBy := q.OrderBySQL()
+	limit := q.LimitSQL()
+	if orderBy == "" && limit != "" && offsetNeedsOrder(q.dialect) {
+		orderBy = "ORDER BY (SELECT NULL)"
+	}
+	if orderBy != "" {
+		stmt = append(stmt, orderBy)
+	}
+	if limit != "" {
+		stmt = append(stmt, limit)
+	}
+
+	return rebind(q.dialect, strings.Join(stmt, " ")), args, nil
+}
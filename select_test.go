@@ -0,0 +1,70 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelect(t *testing.T) {
+	Convey("Select", t, func() {
+		jsq := NewJSQWithDialect([]string{"name", "age"}, Postgres)
+
+		Convey("Should return error if table name is invalid", func() {
+			_, _, err := jsq.Select("people; DROP TABLE people")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "invalid table name: people; DROP TABLE people")
+		})
+
+		Convey("Should omit the WHERE clause if the filter is empty", func() {
+			err := jsq.Parse(`{}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.Select("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "people"`)
+			So(args, ShouldBeEmpty)
+		})
+
+		Convey("Should omit the WHERE clause if nothing was parsed", func() {
+			sql, _, err := jsq.Select("public.people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "public"."people"`)
+		})
+
+		Convey("Should generate a full statement", func() {
+			err := jsq.ParseFind(`{
+				"filter": {"name": "ben", "age": { "$gt": 20 }},
+				"projection": {"name": 1},
+				"sort": {"age": -1},
+				"limit": 10,
+				"skip": 5
+			}`)
+			So(err, ShouldBeNil)
+			sql, args, err := jsq.Select("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldStartWith, `SELECT "name" FROM "people" WHERE `)
+			So(sql, ShouldEndWith, ` ORDER BY "age" DESC LIMIT 10 OFFSET 5`)
+			So(sql, ShouldContainSubstring, "$2")
+			So(len(args), ShouldEqual, 2)
+		})
+
+		Convey("Should qualify the table with an alias", func() {
+			err := jsq.SetFields(FieldMap{"age": {Table: "p"}})
+			So(err, ShouldBeNil)
+			err = jsq.Parse(`{"age": 21}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.SelectAs("people", "p")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM "people" "p" WHERE "p"."age" = $1`)
+		})
+
+		Convey("Should add an ORDER BY for paging on SQL Server", func() {
+			jsq := NewJSQWithDialect([]string{"name"}, SQLServer)
+			err := jsq.ParseFind(`{"filter": {"name": "ben"}, "limit": 10}`)
+			So(err, ShouldBeNil)
+			sql, _, err := jsq.Select("people")
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT * FROM [people] WHERE [name] = @p1 ORDER BY (SELECT NULL) OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY`)
+		})
+	})
+}
@@ -0,0 +1,281 @@
+package jsq
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/util"
+	"github.com/iancoleman/strcase"
+)
+
+// xormKeywords holds xorm tag tokens that are not column names
+var xormKeywords = []string{
+	"pk", "null", "notnull", "not", "autoincr", "unique", "index",
+	"extends", "deleted", "created", "updated", "version", "default",
+	"comment", "<-", "->", "local", "cascade", "bool", "bit", "tinyint",
+	"smallint", "mediumint", "int", "integer", "bigint", "char", "varchar",
+	"tinytext", "text", "mediumtext", "longtext", "binary", "varbinary",
+	"date", "datetime", "time", "timestamp", "timestampz", "decimal",
+	"numeric", "real", "float", "double", "blob", "json", "jsonb", "uuid",
+	"serial", "bigserial", "enum", "set",
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// NewJSQFromStruct returns a new instance whose fields are derived
+// from the exported fields of a struct. Public field names are read
+// from the `json` tag, columns from the `xorm` tag (or `db` tag) and
+// field types from the Go types. A field tagged `jsq:"-"` is excluded
+// and `jsq:"ops=$eq,$in"` restricts the operators of a field.
+func NewJSQFromStruct(v interface{}) (*JSQ, error) {
+	fields, err := StructFields(v, "xorm")
+	if err != nil {
+		return nil, err
+	}
+	q := NewJSQ(nil)
+	if err := q.SetFields(fields); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// NewJSQFromGormStruct is like NewJSQFromStruct but
+// reads columns from the `gorm` tag (or `db` tag)
+func NewJSQFromGormStruct(v interface{}) (*JSQ, error) {
+	fields, err := StructFields(v, "gorm")
+	if err != nil {
+		return nil, err
+	}
+	q := NewJSQ(nil)
+	if err := q.SetFields(fields); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// StructFields derives a field map from the exported fields of a struct.
+// columnTag is the tag to read column names from; "xorm", "gorm" or "db".
+// If a column is not tagged, the snake case of the Go field name is used.
+func StructFields(v interface{}, columnTag string) (FieldMap, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct")
+	}
+	var candidates []structField
+	if err := collectStructFields(t, columnTag, 0, map[reflect.Type]bool{}, &candidates); err != nil {
+		return nil, err
+	}
+	return dominantFields(candidates), nil
+}
+
+// structField is a field derived from a struct, with the
+// depth of embedding at which it was found
+type structField struct {
+	name   string
+	field  Field
+	depth  int
+	tagged bool
+
+	// excluded marks a field that is excluded by its tags
+	// but still hides the fields it shadows
+	excluded bool
+}
+
+// collectStructFields adds the fields of a struct type to candidates.
+// Embedded structs and pointers to structs are flattened. A struct
+// that embeds itself, directly or not, is not flattened again.
+func collectStructFields(t reflect.Type, columnTag string, depth int, seen map[reflect.Type]bool, candidates *[]structField) error {
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		if embedded := sf.Type; sf.Anonymous && sf.Tag.Get("json") == "" {
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if seen[embedded] {
+					continue
+				}
+				if err := collectStructFields(embedded, columnTag, depth+1, seen, candidates); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		// skip unexported fields
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		candidate := structField{name: name, depth: depth, tagged: name != ""}
+		if name == "" {
+			candidate.name = sf.Name
+		}
+
+		column, ok := structColumn(sf, columnTag)
+		if !ok || sf.Tag.Get("jsq") == "-" {
+			candidate.excluded = true
+			*candidates = append(*candidates, candidate)
+			continue
+		}
+
+		candidate.field = Field{
+			Column: column,
+			Type:   goFieldType(sf.Type),
+		}
+
+		ops, err := structOps(sf)
+		if err != nil {
+			return fmt.Errorf("field '%s': %s", candidate.name, err)
+		}
+		candidate.field.Ops = ops
+
+		*candidates = append(*candidates, candidate)
+	}
+	return nil
+}
+
+// dominantFields resolves the fields that share a name like
+// encoding/json does: the shallowest field wins, a tagged field
+// wins over untagged fields at the same depth and the name is
+// dropped if several fields remain.
+func dominantFields(candidates []structField) FieldMap {
+	byName := map[string][]structField{}
+	for _, c := range candidates {
+		byName[c.name] = append(byName[c.name], c)
+	}
+
+	fields := FieldMap{}
+	for name, fs := range byName {
+		dominant, conflict := fs[0], false
+		for _, f := range fs[1:] {
+			switch {
+			case f.depth < dominant.depth || (f.depth == dominant.depth && f.tagged && !dominant.tagged):
+				dominant, conflict = f, false
+			case f.depth == dominant.depth && f.tagged == dominant.tagged:
+				conflict = true
+			}
+		}
+		if !conflict && !dominant.excluded {
+			fields[name] = dominant.field
+		}
+	}
+	return fields
+}
+
+// structColumn reads the column name of a struct field.
+// It returns false if the field is ignored by the tag.
+func structColumn(sf reflect.StructField, columnTag string) (string, bool) {
+	var column string
+	switch columnTag {
+	case "xorm":
+		tag := sf.Tag.Get("xorm")
+		if tag == "-" {
+			return "", false
+		}
+		for _, token := range strings.Fields(tag) {
+			if len(token) > 1 && token[0] == '\'' && token[len(token)-1] == '\'' {
+				column = token[1 : len(token)-1]
+				break
+			}
+			if isValidIdentifier(token) && !util.InStringSlice(xormKeywords, strings.ToLower(token)) {
+				column = token
+				break
+			}
+		}
+
+	case "gorm":
+		tag := sf.Tag.Get("gorm")
+		if tag == "-" {
+			return "", false
+		}
+		for _, setting := range strings.Split(tag, ";") {
+			kv := strings.SplitN(setting, ":", 2)
+			if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "column" {
+				column = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+
+	if column == "" {
+		dbTag := strings.Split(sf.Tag.Get("db"), ",")[0]
+		if dbTag == "-" {
+			return "", false
+		}
+		column = dbTag
+	}
+
+	if column == "" {
+		column = strcase.ToSnake(sf.Name)
+	}
+
+	return column, true
+}
+
+// structOps reads the allowed operators from the `jsq` tag
+func structOps(sf reflect.StructField) ([]string, error) {
+	for _, opt := range strings.Split(sf.Tag.Get("jsq"), ";") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "ops" {
+			continue
+		}
+		var ops []string
+		for _, op := range strings.Split(kv[1], ",") {
+			op = strings.TrimSpace(op)
+			if !util.InStringSlice(compareOperators, op) {
+				return nil, fmt.Errorf("unknown operator: %s", op)
+			}
+			ops = append(ops, op)
+		}
+		return ops, nil
+	}
+	return nil, nil
+}
+
+// goFieldType returns the field type of a Go type
+func goFieldType(t reflect.Type) FieldType {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return TypeTimestamp
+	}
+
+	// uuid types are commonly named arrays of 16 bytes
+	if strings.ToUpper(t.Name()) == "UUID" {
+		return TypeUUID
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return TypeString
+	case reflect.Bool:
+		return TypeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeInt
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat
+	case reflect.Map, reflect.Struct:
+		return TypeJSON
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return TypeAny
+		}
+		return TypeJSON
+	}
+	return TypeAny
+}
@@ -0,0 +1,143 @@
+package jsq
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Base struct {
+	ID int64 `json:"id" xorm:"pk autoincr 'id'" gorm:"column:id"`
+}
+
+type Account struct {
+	Base
+	Name      string    `json:"name" xorm:"'full_name'" gorm:"column:full_name"`
+	Age       int       `json:"age" xorm:"age" gorm:"column:age" jsq:"ops=$eq,$in"`
+	Email     string    `json:"email" db:"email_address"`
+	Password  string    `json:"password" jsq:"-"`
+	Internal  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Balance   *float64  `json:"balance" xorm:"decimal(10,2)"`
+	secret    string
+}
+
+func TestStruct(t *testing.T) {
+	Convey("Struct", t, func() {
+
+		Convey("StructFields", func() {
+			Convey("Should return error if value is not a struct", func() {
+				_, err := StructFields("abc", "xorm")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "expected a struct")
+			})
+
+			Convey("Should derive fields from xorm tags", func() {
+				fields, err := StructFields(&Account{}, "xorm")
+				So(err, ShouldBeNil)
+				So(fields, ShouldResemble, FieldMap{
+					"id":         {Column: "id", Type: TypeInt},
+					"name":       {Column: "full_name", Type: TypeString},
+					"age":        {Column: "age", Type: TypeInt, Ops: []string{"$eq", "$in"}},
+					"email":      {Column: "email_address", Type: TypeString},
+					"created_at": {Column: "created_at", Type: TypeTimestamp},
+					"balance":    {Column: "balance", Type: TypeFloat},
+				})
+			})
+
+			Convey("Should derive columns from gorm tags", func() {
+				fields, err := StructFields(Account{}, "gorm")
+				So(err, ShouldBeNil)
+				So(fields["name"].Column, ShouldEqual, "full_name")
+				So(fields["email"].Column, ShouldEqual, "email_address")
+			})
+
+			Convey("Should flatten embedded pointers to structs", func() {
+				type Node struct {
+					*Base
+					*Node
+					Name string `json:"name"`
+				}
+				fields, err := StructFields(Node{}, "xorm")
+				So(err, ShouldBeNil)
+				So(fields, ShouldResemble, FieldMap{
+					"id":   {Column: "id", Type: TypeInt},
+					"name": {Column: "name", Type: TypeString},
+				})
+			})
+
+			Convey("Should resolve shadowed fields like encoding/json", func() {
+				type Author struct {
+					Name   string `json:"name" xorm:"'author_name'"`
+					Email  string `json:"email"`
+					Secret string `json:"secret"`
+				}
+				type Editor struct {
+					Email string `json:"email"`
+				}
+				type Post struct {
+					Author
+					*Editor
+					Name   string `json:"name" xorm:"'title'"`
+					Secret string `json:"secret" jsq:"-"`
+				}
+				fields, err := StructFields(Post{}, "xorm")
+				So(err, ShouldBeNil)
+				So(fields, ShouldResemble, FieldMap{
+					"name": {Column: "title", Type: TypeString},
+				})
+			})
+
+			Convey("Should return error if an operator is unknown", func() {
+				type Invalid struct {
+					Age int `json:"age" jsq:"ops=$eq,$foo"`
+				}
+				_, err := StructFields(Invalid{}, "xorm")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': unknown operator: $foo")
+			})
+		})
+
+		Convey("NewJSQFromStruct", func() {
+			jsq, err := NewJSQFromStruct(Account{})
+			So(err, ShouldBeNil)
+
+			Convey("Should use the derived columns and types", func() {
+				err := jsq.Parse(`{"name": "ben", "age": "21"}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldContainSubstring, "full_name = ?")
+				So(args, ShouldContain, int64(21))
+			})
+
+			Convey("Should return nil if a field is invalid", func() {
+				type Invalid struct {
+					Name string `json:"name" db:"full name"`
+				}
+				jsq, err := NewJSQFromStruct(Invalid{})
+				So(err, ShouldNotBeNil)
+				So(jsq, ShouldBeNil)
+			})
+
+			Convey("Should reject excluded fields", func() {
+				err := jsq.Parse(`{"password": "x"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown query field: password")
+			})
+
+			Convey("Should reject restricted operators", func() {
+				err := jsq.Parse(`{"age": { "$gt": 21 }}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': operator not allowed: $gt")
+			})
+		})
+
+		Convey("NewJSQFromGormStruct", func() {
+			jsq, err := NewJSQFromGormStruct(Account{})
+			So(err, ShouldBeNil)
+			So(jsq.Fields()["name"].Column, ShouldEqual, "full_name")
+		})
+	})
+}
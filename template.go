@@ -0,0 +1,304 @@
+package jsq
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ellcrys/util"
+)
+
+// paramOperators are the compare operators that accept parameters.
+// The SQL they generate does not depend on the value of a parameter.
+var paramOperators = []string{
+	"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin",
+	"$ieq", "$sw", "$ew", "$ct", "$ict", "$like", "$all", "$size",
+}
+
+// kinds of values a parameter can be bound to
+const (
+	paramAny     = ""
+	paramString  = "string"
+	paramInteger = "integer"
+)
+
+// Template is a query whose values may be {"$param": name} placeholders.
+// It is validated once and bound to values any number of times. Every
+// binding of a template generates the same SQL with different arguments,
+// so booleans are bound as arguments rather than written as literals.
+// Like a Compiler, a template is immutable and safe for concurrent use.
+type Template struct {
+	compiler *Compiler
+	node     Node
+
+	// params holds the names of the parameters in the
+	// order they first appear in the query
+	params []string
+
+	// kinds maps parameters to the kind of values they accept
+	kinds map[string]string
+}
+
+// Template parses and validates a JSON query with parameters, e.g.
+// {"age": {"$gt": {"$param": "minAge"}}}. Parameters can be the value
+// of a compare operator, or an element of the array of $in, $nin or $all.
+func (c *Compiler) Template(jsonQuery string) (*Template, error) {
+	node, err := c.q.parseAST([]byte(jsonQuery))
+	if err != nil {
+		return nil, err
+	}
+
+	// validate the query without its parameters
+	bound := &Compiler{q: c.q}
+	bound.q.bindBools = true
+	t := &Template{compiler: bound, node: node, kinds: map[string]string{}}
+	literal, err := Rewrite(node, func(n Node) (Node, error) {
+		switch n := n.(type) {
+		case Compare:
+			return t.compareParams(n)
+		case Expr:
+			if hasParam(n.Value) {
+				return nil, fmt.Errorf("$expr does not support parameters")
+			}
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.compiler.CompileNode(literal); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// compareParams records the parameters of a compare node and
+// returns the node without them, or nil if it only has parameters
+func (t *Template) compareParams(c Compare) (Node, error) {
+	if !hasParam(c.Value) {
+		return c, nil
+	}
+	kind, err := t.compiler.q.paramKind(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var literals []interface{}
+	switch c.Op {
+	case "$in", "$nin", "$all":
+		values, _ := c.Value.([]interface{})
+		for _, v := range values {
+			if name, ok := paramName(v); ok {
+				names = append(names, name)
+				continue
+			}
+			literals = append(literals, v)
+		}
+	default:
+		if name, ok := paramName(c.Value); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 || hasParam(literals) {
+		return nil, fmt.Errorf("field '%s': '%s' operator expects {\"$param\": name} parameters", c.Field, c.Op)
+	}
+	for _, name := range names {
+		if err := t.addParam(name, kind); err != nil {
+			return nil, err
+		}
+	}
+
+	// keep the literal values of an array operator
+	if len(literals) > 0 {
+		c.Value = literals
+		return c, nil
+	}
+	return nil, nil
+}
+
+// addParam records a parameter and the kind of values it accepts
+func (t *Template) addParam(name, kind string) error {
+	current, ok := t.kinds[name]
+	switch {
+	case !ok:
+		t.params = append(t.params, name)
+	case kind == paramAny:
+		return nil
+	case current != paramAny && current != kind:
+		return fmt.Errorf("parameter '%s': expects both %s and %s values", name, current, kind)
+	}
+	t.kinds[name] = kind
+	return nil
+}
+
+// paramKind ensures a parameter can be the value of a compare
+// node and returns the kind of values the parameter accepts
+func (q *JSQ) paramKind(c Compare) (string, error) {
+	if name, ok := q.relationOf(c.Field); ok {
+		scope := q.relationScope(name)
+		return scope.paramKind(c)
+	}
+
+	insensitive, err := q.checkCompare(c)
+	if err != nil {
+		return "", err
+	}
+	if !util.InStringSlice(paramOperators, c.Op) {
+		return "", fmt.Errorf("field '%s': '%s' operator does not support parameters", c.Field, c.Op)
+	}
+
+	// the SQL of json paths depends on the type of values
+	if q.isJSONPath(c.Field) {
+		return "", fmt.Errorf("field '%s': json paths do not support parameters", c.Field)
+	}
+
+	switch c.Op {
+	case "$like":
+		if !q.allowLikePatterns {
+			return "", fmt.Errorf("field '%s': '$like' operator is not enabled", c.Field)
+		}
+		fallthrough
+	case "$ieq", "$sw", "$ew", "$ct", "$ict":
+		if err := q.requireStringField(c.Field, c.Op); err != nil {
+			return "", err
+		}
+		return paramString, nil
+
+	case "$size":
+		return paramInteger, nil
+
+	case "$eq", "$ne":
+		// only strings are compared ignoring case
+		f, _ := q.getField(c.Field)
+		if insensitive && isStringType(f.Type) {
+			return paramString, nil
+		}
+	}
+	return paramAny, nil
+}
+
+// paramName returns the name of a {"$param": name} placeholder
+func paramName(v interface{}) (string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	name, ok := m["$param"].(string)
+	return name, ok && name != ""
+}
+
+// hasParam checks whether a value contains a $param key
+func hasParam(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if _, ok := v["$param"]; ok {
+			return true
+		}
+		for _, e := range v {
+			if hasParam(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if hasParam(e) {
+				return true
+			}
+		}
+	case Node:
+		var found bool
+		Walk(v, func(n Node) bool {
+			if c, ok := n.(Compare); ok && hasParam(c.Value) {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+	return false
+}
+
+// Params returns the names of the parameters of the template
+func (t *Template) Params() []string {
+	return append([]string(nil), t.params...)
+}
+
+// Bind binds values to every parameter of the template and generates
+// its SQL. Values must be strings, numbers or booleans, and are checked
+// against the fields and operators of the parameters.
+func (t *Template) Bind(params map[string]interface{}) (*CompiledQuery, error) {
+	var unknown []string
+	for name := range params {
+		if _, ok := t.kinds[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameter: %s", unknown[0])
+	}
+
+	values := make(map[string]interface{}, len(t.params))
+	for _, name := range t.params {
+		v, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter: %s", name)
+		}
+		v, err := paramValue(name, t.kinds[name], v)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+
+	node, _ := Rewrite(t.node, func(n Node) (Node, error) {
+		if c, ok := n.(Compare); ok {
+			c.Value = bindParams(c.Value, values)
+			return c, nil
+		}
+		return n, nil
+	})
+	return t.compiler.CompileNode(node)
+}
+
+// paramValue type-checks the value of a parameter against the kind of
+// values it accepts. Integers are returned as float64 like json numbers.
+func paramValue(name, kind string, v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string, bool, int, int32, int64, float32, float64:
+	default:
+		return nil, fmt.Errorf("parameter '%s': expects a string, number or boolean", name)
+	}
+	switch kind {
+	case paramString:
+		if _, ok := v.(string); !ok {
+			return nil, fmt.Errorf("parameter '%s': expects a string", name)
+		}
+	case paramInteger:
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+		return nil, fmt.Errorf("parameter '%s': expects an integer", name)
+	}
+	return v, nil
+}
+
+// bindParams replaces the parameters of a compare value with their values
+func bindParams(v interface{}, values map[string]interface{}) interface{} {
+	if name, ok := paramName(v); ok {
+		return values[name]
+	}
+	if vs, ok := v.([]interface{}); ok {
+		bound := make([]interface{}, len(vs))
+		for i, e := range vs {
+			bound[i] = bindParams(e, values)
+		}
+		return bound
+	}
+	return v
+}
@@ -0,0 +1,142 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplate(t *testing.T) {
+	Convey("Template", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		So(jsq.SetFields(FieldMap{
+			"name":  {CaseInsensitive: true},
+			"age":   {Type: TypeInt},
+			"email": {Type: TypeString},
+			"tags":  {Type: TypeArray, Items: TypeString},
+			"meta":  {Type: TypeJSON},
+		}), ShouldBeNil)
+		compiler := jsq.Compiler()
+
+		Convey(".Template", func() {
+			Convey("Should record the parameters of a query", func() {
+				tmpl, err := compiler.Template(`{"$or": [{"age": {"$gt": {"$param": "minAge"}}}, {"email": {"$in": ["a@example.com", {"$param": "email"}]}}], "age": {"$lt": {"$param": "maxAge"}}}`)
+				So(err, ShouldBeNil)
+				So(tmpl.Params(), ShouldResemble, []string{"minAge", "email", "maxAge"})
+			})
+
+			Convey("Should validate the literal values of the query", func() {
+				_, err := compiler.Template(`{"age": {"$gt": {"$param": "minAge"}, "$lt": "old"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': '$lt' operator expects int value")
+			})
+
+			Convey("Should return error if a field is unknown", func() {
+				_, err := compiler.Template(`{"password": {"$eq": {"$param": "password"}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown query field: password")
+			})
+
+			Convey("Should return error if an operator does not support parameters", func() {
+				_, err := compiler.Template(`{"name": {"$exists": {"$param": "exists"}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': '$exists' operator does not support parameters")
+
+				_, err = compiler.Template(`{"meta.color": {"$eq": {"$param": "color"}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'meta.color': json paths do not support parameters")
+
+				_, err = compiler.Template(`{"$expr": {"$gt": ["$age", {"$param": "age"}]}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "$expr does not support parameters")
+			})
+
+			Convey("Should return error if a parameter is malformed", func() {
+				_, err := compiler.Template(`{"age": {"$in": {"$param": "ages"}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `field 'age': '$in' operator expects {"$param": name} parameters`)
+
+				_, err = compiler.Template(`{"age": {"$eq": {"$param": 1}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `field 'age': '$eq' operator expects {"$param": name} parameters`)
+			})
+
+			Convey("Should return error if a parameter expects different kinds of values", func() {
+				_, err := compiler.Template(`{"name": {"$sw": {"$param": "v"}}, "tags": {"$size": {"$param": "v"}}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "parameter 'v': expects both string and integer values")
+			})
+		})
+
+		Convey(".Bind", func() {
+			tmpl, err := compiler.Template(`{"age": {"$gte": {"$param": "minAge"}}, "name": {"$eq": {"$param": "name"}}, "tags": {"$all": [{"$param": "tag"}, "go"]}}`)
+			So(err, ShouldBeNil)
+
+			Convey("Should generate the same SQL for every binding", func() {
+				q, err := tmpl.Bind(map[string]interface{}{"minAge": 21, "name": "Ben", "tag": "sql"})
+				So(err, ShouldBeNil)
+				So(q.SQL(), ShouldEqual, `"age" >= $1 AND LOWER("name") = LOWER($2) AND "tags" @> ARRAY[$3,$4]`)
+				So(q.Args(), ShouldResemble, []interface{}{int64(21), "Ben", "sql", "go"})
+
+				q2, err := tmpl.Bind(map[string]interface{}{"minAge": 30.0, "name": "joe", "tag": "db"})
+				So(err, ShouldBeNil)
+				So(q2.SQL(), ShouldEqual, q.SQL())
+				So(q2.Args(), ShouldResemble, []interface{}{int64(30), "joe", "db", "go"})
+			})
+
+			Convey("Should bind booleans as arguments", func() {
+				So(jsq.SetFields(FieldMap{"active": {Type: TypeBool}, "label": {}}), ShouldBeNil)
+				compiler := jsq.Compiler()
+
+				tmpl, err := compiler.Template(`{"active": {"$eq": {"$param": "active"}}, "label": {"$ne": {"$param": "label"}}}`)
+				So(err, ShouldBeNil)
+				q, err := tmpl.Bind(map[string]interface{}{"active": true, "label": true})
+				So(err, ShouldBeNil)
+				So(q.SQL(), ShouldEqual, `"active" = $1 AND "label" <> $2`)
+				So(q.Args(), ShouldResemble, []interface{}{true, true})
+
+				for _, params := range []map[string]interface{}{
+					{"active": false, "label": "red"},
+					{"active": "true", "label": 1},
+				} {
+					q2, err := tmpl.Bind(params)
+					So(err, ShouldBeNil)
+					So(q2.SQL(), ShouldEqual, q.SQL())
+				}
+			})
+
+			Convey("Should type-check values", func() {
+				_, err := tmpl.Bind(map[string]interface{}{"minAge": "old", "name": "ben", "tag": "sql"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': '$gte' operator expects int value")
+
+				_, err = tmpl.Bind(map[string]interface{}{"minAge": 21, "name": 1, "tag": "sql"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "parameter 'name': expects a string")
+
+				_, err = tmpl.Bind(map[string]interface{}{"minAge": nil, "name": "ben", "tag": "sql"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "parameter 'minAge': expects a string, number or boolean")
+			})
+
+			Convey("Should return error if a parameter is missing or unknown", func() {
+				_, err := tmpl.Bind(map[string]interface{}{"minAge": 21, "name": "ben"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "missing parameter: tag")
+
+				_, err = tmpl.Bind(map[string]interface{}{"minAge": 21, "name": "ben", "tag": "sql", "limit": 1})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown parameter: limit")
+			})
+
+			Convey("Should bind integers to $size", func() {
+				tmpl, err := compiler.Template(`{"tags": {"$size": {"$param": "n"}}}`)
+				So(err, ShouldBeNil)
+				q, err := tmpl.Bind(map[string]interface{}{"n": 2})
+				So(err, ShouldBeNil)
+				So(q.SQL(), ShouldEqual, `cardinality("tags") = $1`)
+				So(q.Args(), ShouldResemble, []interface{}{int64(2)})
+			})
+		})
+	})
+}
@@ -0,0 +1,299 @@
+package jsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var updateOperators = []string{
+	"$set",         // set value
+	"$unset",       // set to null
+	"$inc",         // increment by value
+	"$mul",         // multiply by value
+	"$min",         // set if value is less
+	"$max",         // set if value is greater
+	"$currentDate", // set to current date or timestamp
+	"$rename",      // move value to another field
+}
+
+// assignment describes a parsed update of a field
+type assignment struct {
+	field string
+	op    string
+	value interface{}
+}
+
+// AllowUnfilteredWrites permits statements that modify
+// every row of a table because the filter is empty
+func (q *JSQ) AllowUnfilteredWrites(allow bool) {
+	q.allowUnfilteredWrites = allow
+}
+
+// ParseUpdate parses an update document such as:
+//
+//	{"$set": {"name": "ben"}, "$inc": {"visits": 1}}
+//
+// Fields are validated against the whitelist and values
+// against the field types. A field can only be updated once.
+func (q *JSQ) ParseUpdate(jsonUpdate string) error {
+	q.assignments = nil
+	q.upsert = nil
+	if err := q.checkInputLimit([]byte(jsonUpdate)); err != nil {
+		return err
+	}
+	ops, err := orderedKeys([]byte(jsonUpdate))
+	if err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonUpdate), &doc); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("update: expects at least one operator")
+	}
+
+	var assignments []assignment
+	updated := map[string]bool{}
+	for _, op := range ops {
+		if !q.isValidOperator(op, updateOperators) {
+			return fmt.Errorf("update: unknown operator: %s", op)
+		}
+
+		fields, err := orderedKeys(doc[op])
+		if err != nil {
+			return fmt.Errorf("update: '%s' operator expects an object", op)
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal(doc[op], &values); err != nil {
+			return fmt.Errorf("update: '%s' operator expects an object", op)
+		}
+
+		for _, field := range fields {
+			a, err := q.parseAssignment(field, op, values[field])
+			if err != nil {
+				return err
+			}
+
+			// a rename updates both the source and target fields
+			targets := []string{field}
+			if op == "$rename" {
+				targets = append(targets, a.value.(string))
+			}
+			for _, target := range targets {
+				if updated[target] {
+					return fmt.Errorf("update: field '%s' is updated more than once", target)
+				}
+				updated[target] = true
+			}
+
+			assignments = append(assignments, a)
+		}
+	}
+
+	q.assignments = assignments
+	return nil
+}
+
+// parseAssignment validates the update of a field
+func (q *JSQ) parseAssignment(field, op string, v interface{}) (assignment, error) {
+	a := assignment{field: field, op: op}
+	if !q.isValidField(field) {
+		return a, fmt.Errorf("update: unknown field: %s", field)
+	}
+	if q.isJSONPath(field) {
+		return a, fmt.Errorf("update: field '%s': json paths cannot be updated", field)
+	}
+	if q.isArrayField(field) && op != "$unset" {
+		return a, fmt.Errorf("field '%s': '%s' operator is not supported by array fields", field, op)
+	}
+	if err := q.checkStringLimit(field, v); err != nil {
+		return a, err
+	}
+
+	switch op {
+	case "$set":
+		if !q.isScalar(v) {
+			return a, fmt.Errorf("field '%s': '$set' operator supports only string, number, boolean or null type", field)
+		}
+		value, err := q.value(field, op, v)
+		if err != nil {
+			return a, err
+		}
+		a.value = value
+
+	case "$unset":
+
+	case "$inc", "$mul":
+		f, _ := q.getField(field)
+		if !q.isNumber(v) || !isNumericType(f.Type) {
+			return a, fmt.Errorf("field '%s': '%s' operator supports only number type", field, op)
+		}
+		value, err := q.value(field, op, v)
+		if err != nil {
+			return a, err
+		}
+		a.value = value
+
+	case "$min", "$max":
+		if !q.isString(v) && !q.isNumber(v) {
+			return a, fmt.Errorf("field '%s': '%s' operator supports only number or string type", field, op)
+		}
+		value, err := q.value(field, op, v)
+		if err != nil {
+			return a, err
+		}
+		a.value = value
+
+	case "$currentDate":
+		switch v {
+		case true:
+			a.value = "timestamp"
+		default:
+			m, ok := v.(map[string]interface{})
+			if !ok || len(m) != 1 || (m["$type"] != "timestamp" && m["$type"] != "date") {
+				return a, fmt.Errorf("field '%s': '$currentDate' operator expects true or {\"$type\": \"timestamp\" | \"date\"}", field)
+			}
+			a.value = m["$type"]
+		}
+
+	case "$rename":
+		target, ok := v.(string)
+		if !ok {
+			return a, fmt.Errorf("field '%s': '$rename' operator supports only string type", field)
+		}
+		if !q.isValidField(target) {
+			return a, fmt.Errorf("update: unknown field: %s", target)
+		}
+		if q.isJSONPath(target) {
+			return a, fmt.Errorf("update: field '%s': json paths cannot be updated", target)
+		}
+		if target == field {
+			return a, fmt.Errorf("field '%s': '$rename' target must be another field", field)
+		}
+		a.value = target
+	}
+
+	return a, nil
+}
+
+// isNumericType checks whether a field type holds numbers
+func isNumericType(t FieldType) bool {
+	return t == TypeAny || t == TypeInt || t == TypeFloat || t == TypeDecimal
+}
+
+// setSQL returns the SET clause of the parsed update with '?'
+// placeholders. If qualifier is set, it qualifies the columns read
+// by the assignments. The table of the field is never used, as it
+// is not declared by the statement.
+func (q *JSQ) setSQL(qualifier string) (string, []interface{}) {
+	var sets []string
+	var args []interface{}
+	for _, a := range q.assignments {
+		target := q.updateColumn(a.field)
+		column := target
+		if qualifier != "" {
+			column = qualifier + "." + target
+		}
+		switch a.op {
+		case "$set":
+			if b, ok := a.value.(bool); ok {
+				sets = append(sets, fmt.Sprintf("%s = %s", target, q.dialect.Bool(b)))
+				continue
+			}
+			if a.value == nil {
+				sets = append(sets, fmt.Sprintf("%s = NULL", target))
+				continue
+			}
+			sets = append(sets, fmt.Sprintf("%s = ?", target))
+			args = append(args, a.value)
+		case "$unset":
+			sets = append(sets, fmt.Sprintf("%s = NULL", target))
+		case "$inc":
+			// a NULL column is treated as 0, like a missing field
+			sets = append(sets, fmt.Sprintf("%s = COALESCE(%s, 0) + ?", target, column))
+			args = append(args, a.value)
+		case "$mul":
+			sets = append(sets, fmt.Sprintf("%s = COALESCE(%s, 0) * ?", target, column))
+			args = append(args, a.value)
+		case "$min":
+			sets = append(sets, fmt.Sprintf("%s = CASE WHEN %s IS NULL OR ? < %s THEN ? ELSE %s END", target, column, column, column))
+			args = append(args, a.value, a.value)
+		case "$max":
+			sets = append(sets, fmt.Sprintf("%s = CASE WHEN %s IS NULL OR ? > %s THEN ? ELSE %s END", target, column, column, column))
+			args = append(args, a.value, a.value)
+		case "$currentDate":
+			if a.value == "date" {
+				sets = append(sets, fmt.Sprintf("%s = CURRENT_DATE", target))
+			} else {
+				sets = append(sets, fmt.Sprintf("%s = CURRENT_TIMESTAMP", target))
+			}
+		case "$rename":
+			sets = append(sets, fmt.Sprintf("%s = %s", q.updateColumn(a.value.(string)), column))
+			sets = append(sets, fmt.Sprintf("%s = NULL", target))
+		}
+	}
+	return strings.Join(sets, ", "), args
+}
+
+// updateColumn returns the quoted column of a field without
+// table qualification, as required by the SET clause
+func (q *JSQ) updateColumn(field string) string {
+	f, ok := q.getField(field)
+	if !ok {
+		f.Column = field
+	}
+	return q.dialect.QuoteIdent(f.Column)
+}
+
+// Update returns an UPDATE statement that applies the parsed update
+// document to the rows of table that match the parsed filter. It
+// returns error if the filter is empty or matches every row, unless
+// unfiltered writes are allowed. If an upsert request was parsed
+// by ParseUpdateRequest, it returns the statement of Upsert.
+func (q *JSQ) Update(table string) (string, []interface{}, error) {
+	if q.upsert != nil {
+		return q.Upsert(table)
+	}
+	name, err := q.quoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(q.assignments) == 0 {
+		return "", nil, fmt.Errorf("update: no update document parsed")
+	}
+
+	scoped, err := q.writeScope(table)
+	if err != nil {
+		return "", nil, err
+	}
+	where, whereArgs, err := scoped.writeFilterSQL("update")
+	if err != nil {
+		return "", nil, err
+	}
+
+	set, args := q.setSQL("")
+	stmt := fmt.Sprintf("UPDATE %s SET %s", name, set)
+	if where != "" {
+		stmt += " WHERE " + where
+		args = append(args, whereArgs...)
+	}
+	return rebind(q.dialect, stmt), args, nil
+}
+
+// writeScope returns the query to generate a write statement over
+// table. The filter is rebuilt with unqualified columns, so that
+// fields of a qualified table refer to the modified table.
+func (q *JSQ) writeScope(table string) (*JSQ, error) {
+	scoped, err := q.scopeTable(table, "")
+	if err != nil || q.node == nil {
+		return scoped, err
+	}
+	c := *scoped
+	c.unqualified = true
+	if err := c.build(q.node); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,177 @@
+package jsq
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdate(t *testing.T) {
+	Convey("Update", t, func() {
+		jsq := NewJSQWithDialect(nil, Postgres)
+		err := jsq.SetFields(FieldMap{
+			"name":    {Type: TypeString},
+			"age":     {Type: TypeInt},
+			"active":  {Type: TypeBool},
+			"seen":    {Column: "last_seen", Type: TypeTimestamp},
+			"nick":    {Type: TypeString},
+			"balance": {Type: TypeDecimal},
+		})
+		So(err, ShouldBeNil)
+
+		Convey(".ParseUpdate", func() {
+			Convey("Should return error if json is malformed", func() {
+				err := jsq.ParseUpdate(`{"$set": `)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "malformed json")
+			})
+
+			Convey("Should return error if the document is empty", func() {
+				err := jsq.ParseUpdate(`{}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: expects at least one operator")
+			})
+
+			Convey("Should return error if operator is unknown", func() {
+				err := jsq.ParseUpdate(`{"$push": {"name": "ben"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: unknown operator: $push")
+			})
+
+			Convey("Should return error if field is not whitelisted", func() {
+				err := jsq.ParseUpdate(`{"$set": {"password": "x"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: unknown field: password")
+			})
+
+			Convey("Should return error if value does not match the field type", func() {
+				err := jsq.ParseUpdate(`{"$set": {"age": "old"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'age': '$set' operator expects int value")
+			})
+
+			Convey("Should return error if $inc is applied to a non-numeric field", func() {
+				err := jsq.ParseUpdate(`{"$inc": {"name": 1}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "field 'name': '$inc' operator supports only number type")
+			})
+
+			Convey("Should return error if a field is updated more than once", func() {
+				err := jsq.ParseUpdate(`{"$set": {"age": 1}, "$inc": {"age": 1}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: field 'age' is updated more than once")
+				err = jsq.ParseUpdate(`{"$set": {"nick": "b"}, "$rename": {"name": "nick"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: field 'nick' is updated more than once")
+			})
+
+			Convey("Should return error if $currentDate type is invalid", func() {
+				err := jsq.ParseUpdate(`{"$currentDate": {"seen": {"$type": "time"}}}`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return error if $rename target is unknown", func() {
+				err := jsq.ParseUpdate(`{"$rename": {"name": "password"}}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: unknown field: password")
+			})
+		})
+
+		Convey(".Update", func() {
+			Convey("Should return error if no update document was parsed", func() {
+				err := jsq.Parse(`{"age": 20}`)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Update("people")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "update: no update document parsed")
+			})
+
+			Convey("Should refuse to update all rows", func() {
+				err := jsq.Parse(`{}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{"$set": {"active": false}}`)
+				So(err, ShouldBeNil)
+				_, _, err = jsq.Update("people")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "refusing to update all rows: filter is empty")
+
+				Convey("Unless unfiltered writes are allowed", func() {
+					jsq.AllowUnfilteredWrites(true)
+					sql, args, err := jsq.Update("people")
+					So(err, ShouldBeNil)
+					So(sql, ShouldEqual, `UPDATE "people" SET "active" = FALSE`)
+					So(args, ShouldBeEmpty)
+				})
+			})
+
+			Convey("Should generate assignments in document order", func() {
+				err := jsq.Parse(`{"name": "ben"}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{
+					"$set": {"nick": "benny", "active": true},
+					"$inc": {"age": 1},
+					"$mul": {"balance": 1.5},
+					"$unset": {"name": ""},
+					"$currentDate": {"seen": true}
+				}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "nick" = $1, "active" = TRUE, "age" = COALESCE("age", 0) + $2, "balance" = COALESCE("balance", 0) * $3, "name" = NULL, "last_seen" = CURRENT_TIMESTAMP WHERE "name" = $4`)
+				So(args, ShouldResemble, []interface{}{"benny", int64(1), "1.5", "ben"})
+			})
+
+			Convey("Should treat NULL columns as 0 in $inc and $mul", func() {
+				err := jsq.Parse(`{"age": {"$exists": false}}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{"$inc": {"age": 2}, "$mul": {"balance": 3}}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "age" = COALESCE("age", 0) + $1, "balance" = COALESCE("balance", 0) * $2 WHERE "age" IS NULL`)
+				So(args, ShouldResemble, []interface{}{int64(2), "3"})
+			})
+
+			Convey("Should not qualify the columns of qualified fields", func() {
+				err := jsq.SetFields(FieldMap{
+					"id":  {Table: "p", Type: TypeInt},
+					"age": {Table: "p", Type: TypeInt},
+				})
+				So(err, ShouldBeNil)
+				err = jsq.Parse(`{"id": 7}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{"$inc": {"age": 1}}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "age" = COALESCE("age", 0) + $1 WHERE "id" = $2`)
+				So(args, ShouldResemble, []interface{}{int64(1), int64(7)})
+
+				sql, _, err = jsq.ToSQL()
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `"p"."id" = $1`)
+			})
+
+			Convey("Should generate $min and $max", func() {
+				err := jsq.Parse(`{"name": "ben"}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{"$min": {"age": 18}}`)
+				So(err, ShouldBeNil)
+				sql, args, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "age" = CASE WHEN "age" IS NULL OR $1 < "age" THEN $2 ELSE "age" END WHERE "name" = $3`)
+				So(args, ShouldResemble, []interface{}{int64(18), int64(18), "ben"})
+			})
+
+			Convey("Should generate $rename", func() {
+				err := jsq.Parse(`{"age": 20}`)
+				So(err, ShouldBeNil)
+				err = jsq.ParseUpdate(`{"$rename": {"name": "nick"}}`)
+				So(err, ShouldBeNil)
+				sql, _, err := jsq.Update("people")
+				So(err, ShouldBeNil)
+				So(sql, ShouldEqual, `UPDATE "people" SET "nick" = "name", "name" = NULL WHERE "age" = $1`)
+			})
+		})
+	})
+}